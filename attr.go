@@ -0,0 +1,111 @@
+package bencode
+
+// Padding files and extended file attributes
+// http://bittorrent.org/beps/bep_0047.html
+
+import (
+	"errors"
+	"path"
+	"strings"
+)
+
+var (
+	NoFileHashError = errors.New("no per-file sha1")
+)
+
+// FileAttr holds the letters of an `attr` string.
+type FileAttr uint8
+
+const (
+	AttrPadding    FileAttr = 1 << iota // p
+	AttrExecutable                      // x
+	AttrHidden                          // h
+	AttrSymlink                         // l
+)
+
+var attrLetters = []struct {
+	attr   FileAttr
+	letter byte
+}{
+	{AttrPadding, 'p'},
+	{AttrExecutable, 'x'},
+	{AttrHidden, 'h'},
+	{AttrSymlink, 'l'},
+}
+
+// ParseFileAttr ignores letters it does not know, as the BEP asks.
+func ParseFileAttr(s string) FileAttr {
+	var a FileAttr
+	for i := 0; i < len(s); i++ {
+		for _, al := range attrLetters {
+			if s[i] == al.letter {
+				a |= al.attr
+			}
+		}
+	}
+	return a
+}
+
+func (a FileAttr) String() string {
+	var b []byte
+	for _, al := range attrLetters {
+		if a&al.attr != 0 {
+			b = append(b, al.letter)
+		}
+	}
+	return string(b)
+}
+
+// fileAttrs reads attr, symlink path and sha1 from a file dict, or from
+// the info dict of a single-file torrent.
+func fileAttrs(m map[string]BNode, fe *FileEntry) {
+	if n, ok := m["attr"]; ok && n.IsBytes() {
+		fe.Attr = ParseFileAttr(n.AsString())
+	}
+	if n, ok := m["symlink path"]; ok && n.Cat == BNodeList {
+		for _, p := range n.List {
+			fe.SymlinkPath = append(fe.SymlinkPath, p.AsString())
+		}
+	}
+	if n, ok := m["sha1"]; ok && n.IsBytes() && len(n.AsString()) == 20 {
+		fe.SHA1 = n.AsBinary()
+	}
+}
+
+// older clients mark padding by name only
+func isPaddingPath(ps []string) bool {
+	if len(ps) == 0 {
+		return false
+	}
+	return ps[0] == ".pad" || strings.HasPrefix(path.Base(ps[len(ps)-1]), "_____padding_file")
+}
+
+// IsPadding tells filler entries that align files to pieces. Their data
+// is zeros and never lives on disk.
+func (fe FileEntry) IsPadding() bool {
+	return fe.Attr&AttrPadding != 0 || isPaddingPath(fe.Path)
+}
+
+func (fe FileEntry) IsSymlink() bool {
+	return fe.Attr&AttrSymlink != 0
+}
+
+func (fe FileEntry) IsExecutable() bool {
+	return fe.Attr&AttrExecutable != 0
+}
+
+func (fe FileEntry) IsHidden() bool {
+	return fe.Attr&AttrHidden != 0
+}
+
+func isPaddingInfo(fi map[string]BNode) bool {
+	if n, ok := fi["attr"]; ok && n.IsBytes() && ParseFileAttr(n.AsString())&AttrPadding != 0 {
+		return true
+	}
+	// the padding names are ASCII, no need to decode
+	var ps []string
+	for _, p := range fi["path"].AsList() {
+		ps = append(ps, p.AsString())
+	}
+	return isPaddingPath(ps)
+}
@@ -0,0 +1,92 @@
+package bencode
+
+import (
+	"crypto/sha1"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileAttr(t *testing.T) {
+	a := ParseFileAttr("xhq")
+	if a != AttrExecutable|AttrHidden || a.String() != "xh" {
+		t.Fatalf("attr %v", a)
+	}
+
+	files := []testFile{
+		{[]string{"run.sh"}, patternData(30000, 1)},
+		{[]string{"fill"}, make([]byte, 2768)},
+		{[]string{"data.bin"}, patternData(20000, 2)},
+		{[]string{"latest"}, nil},
+	}
+	raw, _ := buildTestTorrent("pack", 16*1024, files)
+	tr, err := ParseTorrent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := tr.info["files"].List
+	sum := sha1.Sum(files[2].data)
+	list[0].Map["attr"] = NewString("x")
+	list[1].Map["attr"] = NewString("p")
+	list[2].Map["sha1"] = NewString(string(sum[:]))
+	list[3].Map["attr"] = NewString("l")
+	list[3].Map["symlink path"] = BNode{List: []BNode{NewString("data.bin")}, Cat: BNodeList}
+
+	fes := tr.Files()
+	if !fes[0].IsExecutable() || !fes[1].IsPadding() || fes[2].IsPadding() || !fes[3].IsSymlink() {
+		t.Fatalf("attrs %v %v %v %v", fes[0].Attr, fes[1].Attr, fes[2].Attr, fes[3].Attr)
+	}
+	if !reflect.DeepEqual(fes[3].SymlinkPath, []string{"data.bin"}) || len(fes[2].SHA1) != 20 {
+		t.Fatalf("symlink path %v, sha1 %x", fes[3].SymlinkPath, fes[2].SHA1)
+	}
+	if got := tr.GetFileList(); !reflect.DeepEqual(got, []string{"run.sh", "data.bin", "latest"}) {
+		t.Fatalf("listing %v", got)
+	}
+
+	// padding verifies without a file on disk, and is never written
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "pack", []testFile{files[0], files[2]})
+	s := NewStorage(tr, dir)
+	defer s.Close()
+	if have := s.Verify(); have.Count() != tr.PieceCount() {
+		t.Fatalf("verified %v/%v", have.Count(), tr.PieceCount())
+	}
+	if err := s.WritePiece(1, mustPiece(t, s, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pack", "fill")); err == nil {
+		t.Fatal("padding written to disk")
+	}
+	if ok, err := s.VerifyFileSHA1(fes[2]); !ok || err != nil {
+		t.Fatalf("file sha1: %v %v", ok, err)
+	}
+	if _, err := s.VerifyFileSHA1(fes[0]); !errors.Is(err, NoFileHashError) {
+		t.Fatalf("expect NoFileHashError, got %v", err)
+	}
+
+	// symlink and executable bit come back when laid out
+	lib := t.TempDir()
+	srcs := map[int]string{0: filepath.Join(lib, "a"), 2: filepath.Join(lib, "b")}
+	os.WriteFile(srcs[0], files[0].data, 0644)
+	os.WriteFile(srcs[2], files[2].data, 0644)
+	out := t.TempDir()
+	if _, err := tr.Materialize(out, srcs, LayoutOptions{Mode: LinkCopy}); err != nil {
+		t.Fatal(err)
+	}
+	if link, err := os.Readlink(filepath.Join(out, "pack", "latest")); err != nil || link != "data.bin" {
+		t.Fatalf("symlink %v %v", link, err)
+	}
+	if st, _ := os.Stat(filepath.Join(out, "pack", "run.sh")); st.Mode().Perm()&0111 == 0 {
+		t.Fatalf("mode %v", st.Mode())
+	}
+}
+
+func mustPiece(t *testing.T, s *Storage, i int) []byte {
+	b, err := s.ReadPiece(i)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -10,8 +10,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
 	"strconv"
-	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 const (
@@ -20,17 +22,13 @@ const (
 	BNodeString
 	BNodeList
 	BNodeInteger
-	BNodeBinary
-
-	BNodeStringHex
 )
 
 type BNode struct {
-	Map    map[string]BNode
-	Str    *string
-	Int    *int64
-	List   []BNode
-	Binary []byte
+	Map  map[string]BNode
+	Str  *string
+	Int  *int64
+	List []BNode
 
 	Cat int
 }
@@ -39,6 +37,7 @@ var (
 	TypeError       = errors.New("error type")
 	TypeStringError = errors.New("not a string type")
 	RemainsError    = errors.New("error extra bytes")
+	IndexError      = errors.New("index out of range")
 
 	GeneralFormatError = errors.New("general format error")
 	StringFormatError  = errors.New("string format error")
@@ -65,6 +64,7 @@ func (b BNode) AsInt() int64 {
 	return *b.Int
 }
 
+// AsString returns the bytes of a byte string, which need not be text.
 func (b BNode) AsString() string {
 	if b.Cat != BNodeString {
 		panic(TypeError)
@@ -73,10 +73,52 @@ func (b BNode) AsString() string {
 }
 
 func (b BNode) AsBinary() []byte {
-	if b.Cat != BNodeBinary {
+	if b.Cat != BNodeString {
 		panic(TypeError)
 	}
-	return b.Binary
+	return []byte(*b.Str)
+}
+
+// IsBytes tells a byte string node. Every byte string is a BNodeString,
+// whatever its key and whether or not it is text.
+func (b BNode) IsBytes() bool {
+	return b.Cat == BNodeString
+}
+
+// IsUTF8 tells a byte string that is valid UTF-8 text.
+func (b BNode) IsUTF8() bool {
+	return b.IsBytes() && utf8.ValidString(*b.Str)
+}
+
+// IsText tells a byte string that is valid UTF-8 and free of control
+// characters, so it can be shown as it is.
+func (b BNode) IsText() bool {
+	if !b.IsUTF8() {
+		return false
+	}
+	for _, r := range b.AsString() {
+		if unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// DisplayMaxBinary is how many bytes of a binary string Display spells
+// out in hex before summarizing it by length.
+var DisplayMaxBinary = 64
+
+// Display renders a byte string for people: text as it is, short binary
+// strings as hex, anything longer as its length.
+func (b BNode) Display() string {
+	if b.IsText() {
+		return b.AsString()
+	}
+	bs := b.AsBinary()
+	if len(bs) > DisplayMaxBinary {
+		return fmt.Sprintf("<%v bytes>", len(bs))
+	}
+	return hex.EncodeToString(bs)
 }
 
 var (
@@ -87,59 +129,16 @@ func SetVerboseLevel(newLevel int) {
 	VerboseLevel = newLevel
 }
 
-func PrintNode(node BNode, cat int, indent int) {
-	switch cat {
-
-	case BNodeStringHex:
-		fmt.Printf("%v%v\n", strings.Repeat(" ", indent*2), hex.EncodeToString([]byte(*node.Str)))
-
-		//
-	case BNodeBinary:
-		fmt.Printf("%v***\n", strings.Repeat(" ", indent*2))
-	case BNodeString:
-		fmt.Printf("%v%v\n", strings.Repeat(" ", indent*2), *node.Str)
-	case BNodeInteger:
-		fmt.Printf("%v%v\n", strings.Repeat(" ", indent*2), *node.Int)
-	case BNodeList:
-		if len(node.List) == 0 {
-			fmt.Printf("%v[]\n", strings.Repeat(" ", indent*2))
-		} else {
-			fmt.Printf("%v[\n", strings.Repeat(" ", indent*2))
-			for _, b := range node.List {
-				PrintNode(b, b.Cat, indent+1)
-			}
-			fmt.Printf("%v]\n", strings.Repeat(" ", indent*2))
-		}
-	case BNodeMap:
-		if len(node.Map) == 0 {
-			fmt.Printf("%v{}\n", strings.Repeat(" ", indent*2))
-		} else {
-			fmt.Printf("%v{\n", strings.Repeat(" ", indent*2))
-			for k, v := range node.Map {
-				for {
-					if VerboseLevel <= 1 {
-						if BNodeList == v.Cat && ("announce-list" == k || "nodes" == k) {
-							//
-							break
-						}
-					}
-					fmt.Printf("%v<%v>: ", strings.Repeat(" ", (indent+1)*2), k)
-					if BNodeString == v.Cat && ("filehash" == k || "ed2k" == k) {
-						PrintNode(v, BNodeStringHex, 0)
-					} else if v.Cat == BNodeString || v.Cat == BNodeInteger {
-						PrintNode(v, v.Cat, 0)
-					} else {
-						fmt.Printf("\n")
-						PrintNode(v, v.Cat, indent+2)
-					}
-					break
-				}
-			}
-			fmt.Printf("%v}\n", strings.Repeat(" ", indent*2))
-		}
-	default:
-		panic("Unknown cat")
+// PrintNode writes node to stdout as a tree, indent levels deep. Below
+// verbose level 2 the long announce-list and nodes lists are left out.
+func PrintNode(node BNode, indent int) {
+	p := NewPrinter(os.Stdout)
+	if VerboseLevel <= 1 {
+		p.Exclude = []string{"announce-list", "nodes"}
 	}
+	w := &printWriter{w: os.Stdout}
+	w.printf("%v", p.indent(indent))
+	p.tree(w, node, indent)
 }
 
 func isDigit(b byte) bool {
@@ -151,19 +150,7 @@ func scanMap(raw []byte) (map[string]BNode, []byte) {
 	m := make(map[string]BNode)
 	for raw[0] != 'e' {
 		key, nRaw := scanString(raw)
-
-		var valueNode BNode
-		var rRaw []byte
-		if key != "pieces" {
-			valueNode, rRaw = Scan(nRaw)
-		} else {
-			var bytes []byte
-			bytes, rRaw = scanBinaryString(nRaw)
-			valueNode = BNode{
-				Binary: bytes,
-				Cat:    BNodeBinary,
-			}
-		}
+		valueNode, rRaw := Scan(nRaw)
 		m[key] = valueNode
 		raw = rRaw
 	}
@@ -207,26 +194,6 @@ func scanString(raw []byte) (string, []byte) {
 	return str, raw[i+length:]
 }
 
-func scanBinaryString(raw []byte) ([]byte, []byte) {
-	//defer fmt.Println("string")
-	i := 0
-	var lenS string
-	for ; isDigit(raw[i]); i++ {
-		lenS += string(raw[i])
-	}
-	if raw[i] != ':' {
-		fmt.Printf("lenS = <%v>\n", lenS)
-		fmt.Printf("%v\n", string(raw))
-		panic(StringFormatError)
-	}
-	i++
-	length, err := strconv.Atoi(lenS)
-	if err != nil {
-		panic(err)
-	}
-	return raw[i : i+length], raw[i+length:]
-}
-
 func scanInteger(raw []byte) (int64, []byte) {
 	//defer fmt.Println("integer")
 	var str string
@@ -275,8 +242,7 @@ func Scan(raw []byte) (BNode, []byte) {
 			Cat: BNodeInteger,
 		}, nRaw
 	default:
-		fmt.Printf("raw reset:(%v) %v\n", len(raw), string(raw))
-		panic(fmt.Errorf("unknown format:<%v>", lookahead))
+		panic(fmt.Errorf("unknown format: %q with %v byte(s) left", lookahead, len(raw)))
 	}
 }
 
@@ -59,3 +59,58 @@ func TestBencode(t *testing.T) {
 	}
 
 }
+
+func TestEncode(t *testing.T) {
+	for _, s := range []string{
+		"le",
+		"de",
+		"i-42e",
+		"4:spam",
+		"d1:ai0e4:listl2:XXi3ee3:zzzdee",
+		"d6:pieces3:\x00\x01\x02e",
+	} {
+		if got := string(Encode(MustScanString(s))); got != s {
+			t.Errorf("encode %q: got %q", s, got)
+		}
+	}
+
+	// keys come out sorted
+	if got := string(Encode(MustScanString("d1:bi1e1:ai2ee"))); got != "d1:ai2e1:bi1ee" {
+		t.Errorf("keys not sorted: %q", got)
+	}
+
+	for _, s := range []string{"", "l", "d3:abc", "i12", "5:abc", "x", "lxe"} {
+		if _, _, err := TryScan([]byte(s)); err == nil {
+			t.Errorf("TryScan(%q) shall fail", s)
+		}
+	}
+}
+
+func TestByteStrings(t *testing.T) {
+	node := MustScanString("d5:peers6:\x7f\x00\x00\x01\x1a\xe16:pieces3:\x00\x01\x024:name4:spame")
+	m := node.AsMap()
+	for _, k := range []string{"peers", "pieces", "name"} {
+		if m[k].Cat != BNodeString {
+			t.Errorf("%v decoded as %v", k, m[k].Cat)
+		}
+	}
+	if b := m["pieces"].AsBinary(); len(b) != 3 || b[2] != 2 {
+		t.Errorf("pieces %x", b)
+	}
+	if !m["name"].IsText() || m["peers"].IsUTF8() {
+		t.Errorf("utf-8 checks")
+	}
+	if got := m["name"].Display(); got != "spam" {
+		t.Errorf("display %q", got)
+	}
+	if got := m["peers"].Display(); got != "7f0000011ae1" {
+		t.Errorf("display %q", got)
+	}
+	long := NewBytes(make([]byte, 100))
+	if long.Cat != BNodeString || !long.IsBytes() {
+		t.Errorf("NewBytes made a %v node", long.Cat)
+	}
+	if got := long.Display(); got != "<100 bytes>" || long.AsString() != string(make([]byte, 100)) {
+		t.Errorf("display %q", got)
+	}
+}
@@ -0,0 +1,100 @@
+package bencode
+
+import (
+	"math/rand"
+	"sort"
+)
+
+const (
+	DefaultUploadSlots = 4
+
+	// the optimistic unchoke moves on every this many rechoke rounds
+	OptimisticRounds = 3
+)
+
+// ChokeCandidate is what the choker needs to know about one peer.
+// Rates are in bytes per second over the last round.
+type ChokeCandidate struct {
+	Key          string
+	Interested   bool
+	DownloadRate float64
+	UploadRate   float64
+	Snubbed      bool
+}
+
+// Choker is the tit-for-tat choker of BEP 3: the peers giving us the best
+// download rates are unchoked, plus one optimistic unchoke that rotates.
+// While seeding there is nothing to reciprocate, so peers are ranked by how
+// fast they take data from us instead.
+type Choker struct {
+	Slots int
+
+	round      int
+	optimistic string
+	rnd        *rand.Rand
+}
+
+func NewChoker(slots int) *Choker {
+	return &Choker{
+		Slots: slots,
+		rnd:   rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+// Rechoke returns the keys to unchoke. Call it every ten seconds or so.
+func (c *Choker) Rechoke(cands []ChokeCandidate, seeding bool) map[string]bool {
+	unchoke := make(map[string]bool)
+	var interested []ChokeCandidate
+	for _, cand := range cands {
+		if cand.Interested {
+			interested = append(interested, cand)
+		}
+	}
+	rate := func(cand ChokeCandidate) float64 {
+		if seeding {
+			return cand.UploadRate
+		}
+		return cand.DownloadRate
+	}
+	sort.SliceStable(interested, func(i, j int) bool {
+		a, b := interested[i], interested[j]
+		if a.Snubbed != b.Snubbed {
+			return !a.Snubbed
+		}
+		return rate(a) > rate(b)
+	})
+
+	regular := c.Slots - 1
+	if regular < 0 {
+		regular = 0
+	}
+	for i := 0; i < len(interested) && i < regular; i++ {
+		unchoke[interested[i].Key] = true
+	}
+
+	// keep the optimistic unchoke for a few rounds, then pick another one
+	// among the choked interested peers
+	stillThere := false
+	for _, cand := range interested {
+		if cand.Key == c.optimistic {
+			stillThere = true
+		}
+	}
+	if c.round%OptimisticRounds == 0 || !stillThere || unchoke[c.optimistic] {
+		var choked []string
+		for _, cand := range interested {
+			if !unchoke[cand.Key] {
+				choked = append(choked, cand.Key)
+			}
+		}
+		c.optimistic = ""
+		if len(choked) > 0 {
+			c.optimistic = choked[c.rnd.Intn(len(choked))]
+		}
+	}
+	if c.optimistic != "" && c.Slots > 0 {
+		unchoke[c.optimistic] = true
+	}
+	c.round++
+	return unchoke
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/eminom/gobencode"
+)
+
+func cmdCreate(args []string) int {
+	fs := newFlags("create", "[flags] path", "Makes a torrent of a file or a directory.")
+	out := fs.String("o", "", "write the torrent here; the name plus .torrent when empty, - for stdout")
+	pieceLength := fs.Int64("piece-length", 0, "piece length in bytes, a power of two; 0 picks one")
+	name := fs.String("name", "", "torrent name; the base name of path when empty")
+	var trackers, webseeds listFlag
+	fs.Var(&trackers, "tracker", "add a tracker tier; urls of a tier separated by commas (repeatable)")
+	fs.Var(&webseeds, "webseed", "add a url-list web seed (repeatable)")
+	comment := fs.String("comment", "", "comment")
+	createdBy := fs.String("created-by", "gobencode", "created by; empty leaves it out")
+	private := fs.Bool("private", false, "mark the torrent private")
+	source := fs.String("source", "", "source tag")
+	noDate := fs.Bool("no-date", false, "leave the creation date out")
+	asJSON := fs.Bool("json", false, "report in JSON")
+	if !parseFlags(fs, args, 1) {
+		return exitUsage
+	}
+	if pl := *pieceLength; pl != 0 && (pl < bencode.MinPieceLength || pl&(pl-1) != 0) {
+		log.Printf("create: piece length %v is not a power of two of at least %v", pl, bencode.MinPieceLength)
+		return exitUsage
+	}
+
+	opts := bencode.CreateOptions{
+		PieceLength: *pieceLength,
+		Name:        *name,
+		Private:     *private,
+		Source:      *source,
+		WebSeeds:    webseeds,
+		Comment:     *comment,
+		CreatedBy:   *createdBy,
+	}
+	for _, tier := range trackers {
+		opts.Trackers = append(opts.Trackers, strings.Split(tier, ","))
+	}
+	if !*noDate {
+		opts.CreationDate = time.Now()
+	}
+	t, err := bencode.CreateTorrent(fs.Arg(0), opts)
+	if err != nil {
+		log.Print(err)
+		return exitError
+	}
+	if *out == "" {
+		*out = t.Name() + ".torrent"
+	}
+	if !writeOutput(*out, t.Bytes()) {
+		return exitError
+	}
+	if *asJSON && *out != "-" {
+		return printJSON(struct {
+			File     string `json:"file"`
+			InfoHash string `json:"info_hash"`
+		}{*out, t.InfoHashHex()})
+	}
+	if *out != "-" {
+		fmt.Printf("%v %v\n", t.InfoHashHex(), *out)
+	}
+	return exitOK
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/eminom/gobencode"
+)
+
+type diffChange struct {
+	Path     string `json:"path"`
+	Kind     string `json:"kind"`
+	Old      string `json:"old,omitempty"`
+	New      string `json:"new,omitempty"`
+	InfoHash bool   `json:"affects_info_hash"`
+}
+
+func cmdDiff(args []string) int {
+	fs := newFlags("diff", "[flags] old new",
+		"Compares two bencoded files. When both are torrents, changes are split into\n"+
+			"those that change the info-hash and cosmetic ones. Exits 1 when they differ.")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if !parseFlags(fs, args, 2) {
+		return exitUsage
+	}
+	rawA, a, ok := loadNode(fs.Arg(0))
+	if !ok {
+		return exitError
+	}
+	rawB, b, ok := loadNode(fs.Arg(1))
+	if !ok {
+		return exitError
+	}
+
+	ta, errA := bencode.ParseTorrent(rawA)
+	tb, errB := bencode.ParseTorrent(rawB)
+	torrents := errA == nil && errB == nil
+	var changes []bencode.Change
+	var td bencode.TorrentDiff
+	if torrents {
+		td = bencode.DiffTorrents(ta, tb)
+		changes = td.Changes
+	} else {
+		changes = bencode.Diff(a, b)
+	}
+	code := exitOK
+	if len(changes) > 0 || (torrents && td.InfoHashChanged()) {
+		code = exitNo
+	}
+
+	if *asJSON {
+		rs := []diffChange{}
+		for _, c := range changes {
+			r := diffChange{Path: c.Path, Kind: c.Kind.String(), InfoHash: torrents && c.AffectsInfoHash()}
+			if c.Kind != bencode.DiffAdded {
+				r.Old = bencode.Summarize(c.Old)
+			}
+			if c.Kind != bencode.DiffRemoved {
+				r.New = bencode.Summarize(c.New)
+			}
+			rs = append(rs, r)
+		}
+		v := map[string]interface{}{"changes": rs}
+		if torrents {
+			v["old_info_hash"] = fmt.Sprintf("%x", td.OldInfoHash)
+			v["new_info_hash"] = fmt.Sprintf("%x", td.NewInfoHash)
+		}
+		if c := printJSON(v); c != exitOK {
+			return c
+		}
+		return code
+	}
+	if torrents {
+		td.Print(os.Stdout)
+	} else {
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+	}
+	return code
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/eminom/gobencode"
+)
+
+func cmdDump(args []string) int {
+	fs := newFlags("dump", "[flags] file", "Prints any bencoded file; - reads stdin.")
+	format := fs.String("format", "tree", "tree, json or hex")
+	asJSON := fs.Bool("json", false, "short for -format json")
+	binary := fs.String("binary", "summary", "show binary strings as summary, hex or base64")
+	max := fs.Int("max", 0, "cut strings after this many bytes; 0 keeps them whole")
+	unsorted := fs.Bool("unsorted", false, "keep dictionary keys in map order")
+	query := fs.String("q", "", "print only what this path query reaches, like info.files[0].path")
+	var exclude listFlag
+	fs.Var(&exclude, "exclude", "leave out keys matching this pattern (repeatable)")
+	if !parseFlags(fs, args, 1) {
+		return exitUsage
+	}
+
+	p := bencode.NewPrinter(os.Stdout)
+	p.SortKeys = !*unsorted
+	p.MaxString = *max
+	p.Exclude = exclude
+	if *asJSON {
+		*format = "json"
+	}
+	switch *format {
+	case "tree":
+		p.Format = bencode.PrintTree
+	case "json":
+		p.Format = bencode.PrintJSON
+	case "hex":
+		p.Format = bencode.PrintHexdump
+	default:
+		log.Printf("dump: unknown format %q", *format)
+		return exitUsage
+	}
+	switch *binary {
+	case "summary":
+		p.Binary = bencode.BinarySummary
+	case "hex":
+		p.Binary = bencode.BinaryHex
+	case "base64":
+		p.Binary = bencode.BinaryBase64
+	default:
+		log.Printf("dump: unknown binary format %q", *binary)
+		return exitUsage
+	}
+
+	_, node, ok := loadNode(fs.Arg(0))
+	if !ok {
+		return exitError
+	}
+	nodes := []bencode.BNode{node}
+	if *query != "" {
+		var err error
+		if nodes, err = node.Query(*query); err != nil {
+			log.Print(err)
+			return exitNo
+		}
+	}
+	for _, n := range nodes {
+		if err := p.Print(n); err != nil {
+			log.Print(err)
+			return exitError
+		}
+	}
+	return exitOK
+}
+
+func cmdJSON(args []string) int {
+	fs := newFlags("json", "[flags] file",
+		"Converts bencode to JSON, or JSON back with -r, byte for byte: strings that\n"+
+			"are not UTF-8 become {\"$hex\": ...}, integers keep every digit.")
+	reverse := fs.Bool("r", false, "convert JSON back to bencode")
+	b64 := fs.Bool("base64", false, "tag binary strings as base64 rather than hex")
+	indent := fs.Bool("indent", false, "indent the JSON")
+	out := fs.String("o", "", "write here rather than to stdout")
+	if !parseFlags(fs, args, 1) {
+		return exitUsage
+	}
+	in, err := readInput(fs.Arg(0))
+	if err != nil {
+		log.Print(err)
+		return exitError
+	}
+
+	var data []byte
+	if *reverse {
+		data, err = bencode.JSONToBencode(in)
+	} else {
+		opts := bencode.JSONOptions{Base64: *b64}
+		if *indent {
+			opts.Indent = "  "
+		}
+		data, err = bencode.BencodeToJSON(in, opts)
+		data = append(data, '\n')
+	}
+	if err != nil {
+		log.Printf("%v: %v", fs.Arg(0), err)
+		return exitError
+	}
+	if !writeOutput(*out, data) {
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/eminom/gobencode"
+)
+
+func cmdEdit(args []string) int {
+	fs := newFlags("edit", "[flags] file.torrent",
+		"Only the keys around info change unless -private or -source is given,\n"+
+			"which changes the info-hash and needs -force.")
+	var trackers, webseeds listFlag
+	fs.Var(&trackers, "tracker", "replace the trackers; one tier per flag, urls of a tier separated by commas")
+	fs.Var(&webseeds, "webseed", "add a url-list web seed (repeatable)")
+	clearTrackers := fs.Bool("clear-trackers", false, "remove all trackers")
+	comment := fs.String("comment", "", "set the comment; empty removes it")
+	createdBy := fs.String("created-by", "", "set created by; empty removes it")
+	stripDate := fs.Bool("strip-date", false, "remove the creation date")
+	private := fs.String("private", "", "set (1) or clear (0) the private flag; changes the info-hash")
+	source := fs.String("source", "", "set the source tag, empty removes it; changes the info-hash")
+	force := fs.Bool("force", false, "allow edits that change the info-hash")
+	out := fs.String("o", "", "write to this file instead; - for stdout")
+	inPlace := fs.Bool("w", false, "write the result back to the input file")
+	if !parseFlags(fs, args, 1) {
+		return exitUsage
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if (*out == "") == !*inPlace {
+		log.Printf("edit: give either -o or -w")
+		return exitUsage
+	}
+	in := fs.Arg(0)
+	if *inPlace {
+		*out = in
+	}
+	t, ok := loadTorrent(in)
+	if !ok {
+		return exitError
+	}
+	before := t.InfoHashHex()
+
+	switch {
+	case *clearTrackers:
+		t.SetTrackers(nil)
+	case len(trackers) > 0:
+		var tiers [][]string
+		for _, tier := range trackers {
+			tiers = append(tiers, strings.Split(tier, ","))
+		}
+		t.SetTrackers(tiers)
+	}
+	for _, u := range webseeds {
+		t.AddWebSeed(u)
+	}
+	if set["comment"] {
+		t.SetComment(*comment)
+	}
+	if set["created-by"] {
+		t.SetCreatedBy(*createdBy)
+	}
+	if *stripDate {
+		t.SetCreationDate(time.Time{})
+	}
+
+	var infoEdits []error
+	if set["private"] {
+		var v *bencode.BNode
+		switch *private {
+		case "1":
+			n := bencode.NewInt(1)
+			v = &n
+		case "0":
+		default:
+			log.Printf("-private takes 0 or 1")
+			return exitUsage
+		}
+		infoEdits = append(infoEdits, t.SetInfoKey("private", v, *force))
+	}
+	if set["source"] {
+		var v *bencode.BNode
+		if *source != "" {
+			n := bencode.NewString(*source)
+			v = &n
+		}
+		infoEdits = append(infoEdits, t.SetInfoKey("source", v, *force))
+	}
+	refused := false
+	for _, err := range infoEdits {
+		if errors.Is(err, bencode.InfoHashChangeError) {
+			log.Printf("warning: %v; add -force to go ahead", err)
+			refused = true
+		} else if err != nil {
+			log.Print(err)
+			return exitError
+		}
+	}
+	if refused {
+		return exitNo
+	}
+
+	if !writeOutput(*out, t.Bytes()) {
+		return exitError
+	}
+	if after := t.InfoHashHex(); after != before {
+		log.Printf("warning: info-hash changed: %v -> %v", before, after)
+	} else {
+		log.Printf("info-hash %v unchanged", after)
+	}
+	return exitOK
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eminom/gobencode"
+)
+
+type infoReport struct {
+	Name         string     `json:"name"`
+	InfoHash     string     `json:"info_hash"`
+	Size         int64      `json:"size"`
+	Files        int        `json:"files"`
+	PieceLength  int64      `json:"piece_length"`
+	Pieces       int        `json:"pieces"`
+	Private      bool       `json:"private"`
+	Source       string     `json:"source,omitempty"`
+	Trackers     []string   `json:"trackers"`
+	WebSeeds     []string   `json:"web_seeds"`
+	Comment      string     `json:"comment,omitempty"`
+	CreatedBy    string     `json:"created_by,omitempty"`
+	CreationDate *time.Time `json:"creation_date,omitempty"`
+}
+
+// metaString returns a string key of m, or "".
+func metaString(m map[string]bencode.BNode, key string) string {
+	if n, ok := m[key]; ok && n.IsBytes() {
+		return n.AsString()
+	}
+	return ""
+}
+
+func cmdInfo(args []string) int {
+	fs := newFlags("info", "[flags] file.torrent", "")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if !parseFlags(fs, args, 1) {
+		return exitUsage
+	}
+	t, ok := loadTorrent(fs.Arg(0))
+	if !ok {
+		return exitError
+	}
+
+	r := infoReport{
+		Name:        t.Name(),
+		InfoHash:    t.InfoHashHex(),
+		Size:        t.TotalLength(),
+		PieceLength: t.PieceLength(),
+		Pieces:      t.PieceCount(),
+		Source:      metaString(t.Info(), "source"),
+		Trackers:    t.Announces(),
+		WebSeeds:    []string{},
+		Comment:     metaString(t.Meta(), "comment"),
+		CreatedBy:   metaString(t.Meta(), "created by"),
+	}
+	for _, fe := range t.Files() {
+		if !fe.IsPadding() {
+			r.Files++
+		}
+	}
+	if p, ok := t.Info()["private"]; ok && p.Cat == bencode.BNodeInteger && p.AsInt() == 1 {
+		r.Private = true
+	}
+	for _, ws := range t.WebSeeds() {
+		r.WebSeeds = append(r.WebSeeds, ws.URL)
+	}
+	if r.Trackers == nil {
+		r.Trackers = []string{}
+	}
+	if d, ok := t.Meta()["creation date"]; ok && d.Cat == bencode.BNodeInteger {
+		tm := time.Unix(d.AsInt(), 0).UTC()
+		r.CreationDate = &tm
+	}
+	if *asJSON {
+		return printJSON(r)
+	}
+
+	line := func(k string, v interface{}) {
+		fmt.Printf("%-14v %v\n", k+":", v)
+	}
+	line("name", r.Name)
+	line("info-hash", r.InfoHash)
+	line("size", fmt.Sprintf("%v bytes in %v files", r.Size, r.Files))
+	line("piece length", fmt.Sprintf("%v (%v pieces)", r.PieceLength, r.Pieces))
+	line("private", r.Private)
+	if r.Source != "" {
+		line("source", r.Source)
+	}
+	for i, tr := range r.Trackers {
+		if i == 0 {
+			line("trackers", tr)
+		} else {
+			line("", tr)
+		}
+	}
+	for i, ws := range r.WebSeeds {
+		if i == 0 {
+			line("web seeds", ws)
+		} else {
+			line("", ws)
+		}
+	}
+	if r.Comment != "" {
+		line("comment", r.Comment)
+	}
+	if r.CreatedBy != "" {
+		line("created by", r.CreatedBy)
+	}
+	if r.CreationDate != nil {
+		line("created", r.CreationDate.Format(time.RFC3339))
+	}
+	return exitOK
+}
+
+type fileReport struct {
+	Path    string `json:"path"`
+	Length  int64  `json:"length"`
+	Offset  int64  `json:"offset"`
+	Attr    string `json:"attr,omitempty"`
+	Symlink string `json:"symlink,omitempty"`
+}
+
+func cmdFiles(args []string) int {
+	fs := newFlags("files", "[flags] file.torrent", "")
+	asJSON := fs.Bool("json", false, "print JSON")
+	all := fs.Bool("all", false, "include padding files")
+	if !parseFlags(fs, args, 1) {
+		return exitUsage
+	}
+	t, ok := loadTorrent(fs.Arg(0))
+	if !ok {
+		return exitError
+	}
+	rs := []fileReport{}
+	for _, fe := range t.Files() {
+		if fe.IsPadding() && !*all {
+			continue
+		}
+		r := fileReport{Path: strings.Join(fe.Path, "/"), Length: fe.Length, Offset: fe.Offset, Attr: fe.Attr.String()}
+		if fe.IsSymlink() {
+			r.Symlink = strings.Join(fe.SymlinkPath, "/")
+		}
+		rs = append(rs, r)
+	}
+	if *asJSON {
+		return printJSON(rs)
+	}
+	for _, r := range rs {
+		extra := ""
+		if r.Attr != "" {
+			extra = " [" + r.Attr + "]"
+		}
+		if r.Symlink != "" {
+			extra += " -> " + r.Symlink
+		}
+		fmt.Printf("%16v  %v%v\n", r.Length, r.Path, extra)
+	}
+	return exitOK
+}
+
+func cmdMagnet(args []string) int {
+	fs := newFlags("magnet", "[flags] file.torrent", "")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if !parseFlags(fs, args, 1) {
+		return exitUsage
+	}
+	t, ok := loadTorrent(fs.Arg(0))
+	if !ok {
+		return exitError
+	}
+	m := t.Magnet()
+	if *asJSON {
+		return printJSON(struct {
+			Magnet   string `json:"magnet"`
+			InfoHash string `json:"info_hash"`
+		}{m.String(), t.InfoHashHex()})
+	}
+	fmt.Println(m)
+	return exitOK
+}
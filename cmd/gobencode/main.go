@@ -0,0 +1,206 @@
+// Command gobencode inspects and edits bencoded files and torrents.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/eminom/gobencode"
+)
+
+// exit codes, the same for every command
+const (
+	exitOK    = 0
+	exitNo    = 1 // the answer is no: pieces fail, documents differ, edit refused
+	exitUsage = 2
+	exitError = 3 // a file could not be read, parsed or written
+)
+
+type command struct {
+	run   func(args []string) int
+	usage string
+}
+
+var commands = map[string]command{
+	"dump":   {cmdDump, "print any bencoded file as a tree, JSON-like or hexdump"},
+	"info":   {cmdInfo, "summarize a torrent"},
+	"files":  {cmdFiles, "list the files of a torrent"},
+	"verify": {cmdVerify, "hash the data of a torrent found under --dir"},
+	"create": {cmdCreate, "make a torrent of a file or directory"},
+	"edit":   {cmdEdit, "edit top-level keys, keeping the info-hash"},
+	"magnet": {cmdMagnet, "print the magnet link of a torrent"},
+	"diff":   {cmdDiff, "show what changed between two files"},
+	"json":   {cmdJSON, "convert bencode to JSON and back, losslessly"},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: gobencode <command> [flags] [args]\n\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8v %v\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun gobencode <command> -h for its flags\n"+
+		"exit codes: 0 ok, 1 no (failed verify, differences, refused edit), 2 usage, 3 error\n")
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("gobencode: ")
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitUsage)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		if h := os.Args[1]; h == "-h" || h == "-help" || h == "--help" || h == "help" {
+			usage()
+			os.Exit(exitOK)
+		}
+		log.Printf("unknown command %q", os.Args[1])
+		usage()
+		os.Exit(exitUsage)
+	}
+	os.Exit(cmd.run(os.Args[2:]))
+}
+
+// newFlags makes the flag set of a command; synopsis follows its name.
+func newFlags(name, synopsis, about string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: gobencode %v %v\n\n", name, synopsis)
+		if about != "" {
+			fmt.Fprintf(fs.Output(), "%v\n\n", about)
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args and checks the count of positional arguments.
+func parseFlags(fs *flag.FlagSet, args []string, nargs int) bool {
+	if err := fs.Parse(args); err != nil {
+		return false
+	}
+	if fs.NArg() != nargs {
+		fs.Usage()
+		return false
+	}
+	return true
+}
+
+// loadTorrent reads a torrent, refusing one whose info dictionary lacks
+// the keys the commands rely on.
+func loadTorrent(path string) (*bencode.Torrent, bool) {
+	t, err := bencode.LoadTorrent(path)
+	if err != nil {
+		log.Printf("%v: %v", path, err)
+		return nil, false
+	}
+	return t, true
+}
+
+// readInput reads a file; - is stdin.
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// loadNode reads any bencoded file.
+func loadNode(path string) ([]byte, bencode.BNode, bool) {
+	raw, err := readInput(path)
+	if err != nil {
+		log.Print(err)
+		return nil, bencode.BNode{}, false
+	}
+	node, remains, err := bencode.TryScan(raw)
+	if err == nil && len(remains) > 0 {
+		err = bencode.RemainsError
+	}
+	if err != nil {
+		log.Printf("%v: %v", path, err)
+		return nil, bencode.BNode{}, false
+	}
+	return raw, node, true
+}
+
+// writeOutput writes data to path, or stdout for - or no path.
+func writeOutput(path string, data []byte) bool {
+	var err error
+	if path == "" || path == "-" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = replaceFile(path, data)
+	}
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	return true
+}
+
+// replaceFile writes data next to path and renames it over path, so a
+// failed write leaves the old file whole. An existing file keeps its mode.
+func replaceFile(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if st, err := os.Stat(path); err == nil {
+		mode = st.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// printJSON writes v for scripts, indented.
+func printJSON(v interface{}) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Print(err)
+		return exitError
+	}
+	return exitOK
+}
+
+// listFlag collects a repeated string flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMalformedTorrent(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "broken.torrent")
+	if err := os.WriteFile(p, []byte("d4:infod4:name1:xee"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.torrent")
+	for _, c := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{"info", p}, exitError},
+		{[]string{"files", p}, exitError},
+		{[]string{"verify", "-dir", dir, p}, exitError},
+		{[]string{"magnet", p}, exitError},
+		{[]string{"edit", "-comment", "x", "-o", out, p}, exitError},
+		// well-formed bencode all the same
+		{[]string{"dump", p}, exitOK},
+		{[]string{"json", "-o", out, p}, exitOK},
+		{[]string{"diff", p, p}, exitOK},
+	} {
+		if got := commands[c.args[0]].run(c.args[1:]); got != c.want {
+			t.Errorf("%v: exit %v, want %v", c.args, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/eminom/gobencode"
+)
+
+type verifyFile struct {
+	Path     string `json:"path"`
+	Pieces   int    `json:"pieces"`
+	Verified int    `json:"verified"`
+}
+
+type verifyReport struct {
+	InfoHash string       `json:"info_hash"`
+	Pieces   int          `json:"pieces"`
+	Verified int          `json:"verified"`
+	Complete bool         `json:"complete"`
+	Files    []verifyFile `json:"files"`
+}
+
+func cmdVerify(args []string) int {
+	fs := newFlags("verify", "[flags] file.torrent",
+		"Hashes every piece from the files under -dir, laid out as the torrent\n"+
+			"names them. Exits 1 unless every piece verifies.")
+	dir := fs.String("dir", ".", "directory holding the torrent data")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if !parseFlags(fs, args, 1) {
+		return exitUsage
+	}
+	t, ok := loadTorrent(fs.Arg(0))
+	if !ok {
+		return exitError
+	}
+	if err := t.CheckPaths(); err != nil {
+		log.Print(err)
+		return exitError
+	}
+
+	s := bencode.NewStorage(t, *dir)
+	defer s.Close()
+	have := s.Verify()
+	r := verifyReport{
+		InfoHash: t.InfoHashHex(),
+		Pieces:   t.PieceCount(),
+		Verified: have.Count(),
+		Files:    []verifyFile{},
+	}
+	r.Complete = r.Verified == r.Pieces
+	pl := t.PieceLength()
+	for _, fe := range t.Files() {
+		if fe.IsPadding() {
+			continue
+		}
+		vf := verifyFile{Path: strings.Join(fe.Path, "/")}
+		if fe.Length > 0 {
+			for i := fe.Offset / pl; i <= (fe.Offset+fe.Length-1)/pl; i++ {
+				vf.Pieces++
+				if have.Has(int(i)) {
+					vf.Verified++
+				}
+			}
+		}
+		r.Files = append(r.Files, vf)
+	}
+
+	code := exitOK
+	if !r.Complete {
+		code = exitNo
+	}
+	if *asJSON {
+		if c := printJSON(r); c != exitOK {
+			return c
+		}
+		return code
+	}
+	for _, vf := range r.Files {
+		status := "ok"
+		if vf.Verified < vf.Pieces {
+			status = fmt.Sprintf("%v/%v pieces", vf.Verified, vf.Pieces)
+		}
+		fmt.Printf("%-12v %v\n", status, vf.Path)
+	}
+	fmt.Printf("%v/%v pieces verified (%.2f%%)\n", r.Verified, r.Pieces, percent(r.Verified, r.Pieces))
+	return code
+}
+
+func percent(n, of int) float64 {
+	if of == 0 {
+		return 100
+	}
+	return 100 * float64(n) / float64(of)
+}
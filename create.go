@@ -0,0 +1,184 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var EmptyTorrentError = errors.New("nothing to put in the torrent")
+
+const (
+	MinPieceLength = 16 * 1024
+	MaxPieceLength = 16 * 1024 * 1024
+)
+
+type CreateOptions struct {
+	// 0 picks a power of two giving about 1500 pieces
+	PieceLength int64
+
+	Name     string // the base name of the path when empty
+	Private  bool
+	Source   string
+	Trackers [][]string
+	WebSeeds []string
+
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time // left out when zero
+}
+
+// DefaultPieceLength picks a piece length for total bytes of content.
+func DefaultPieceLength(total int64) int64 {
+	pl := int64(MinPieceLength)
+	for pl < MaxPieceLength && total/pl > 1500 {
+		pl *= 2
+	}
+	return pl
+}
+
+// CreateTorrent hashes the file or directory at root into a new torrent.
+// The files of a directory are taken in path order; anything but regular
+// files is skipped.
+func CreateTorrent(root string, opts CreateOptions) (*Torrent, error) {
+	st, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	type source struct {
+		path  string
+		comps []string
+		size  int64
+	}
+	var srcs []source
+	if st.Mode().IsRegular() {
+		srcs = append(srcs, source{path: root, size: st.Size()})
+	} else {
+		err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				log.Printf("create: skipping %v: not a regular file", p)
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			srcs = append(srcs, source{path: p, comps: strings.Split(filepath.ToSlash(rel), "/"), size: fi.Size()})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(srcs, func(i, j int) bool {
+			return strings.Join(srcs[i].comps, "/") < strings.Join(srcs[j].comps, "/")
+		})
+	}
+	if len(srcs) == 0 {
+		return nil, fmt.Errorf("%w: %v", EmptyTorrentError, root)
+	}
+
+	var total int64
+	for _, s := range srcs {
+		total += s.size
+	}
+	pl := opts.PieceLength
+	if pl <= 0 {
+		pl = DefaultPieceLength(total)
+	}
+
+	// hash across file boundaries through one piece buffer
+	var pieces []byte
+	buf := make([]byte, 0, pl)
+	for _, s := range srcs {
+		f, err := os.Open(s.path)
+		if err != nil {
+			return nil, err
+		}
+		left := s.size
+		for left > 0 {
+			n := int64(cap(buf) - len(buf))
+			if n > left {
+				n = left
+			}
+			start := len(buf)
+			buf = buf[:start+int(n)]
+			if _, err := io.ReadFull(f, buf[start:]); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%v: %w", s.path, err)
+			}
+			left -= n
+			if len(buf) == cap(buf) {
+				pieces = append(pieces, calcSha1Hash(buf)...)
+				buf = buf[:0]
+			}
+		}
+		f.Close()
+	}
+	if len(buf) > 0 {
+		pieces = append(pieces, calcSha1Hash(buf)...)
+	}
+
+	name := opts.Name
+	if name == "" {
+		// "." and the like name the directory itself
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		name = filepath.Base(abs)
+	}
+	info := NewDict(map[string]BNode{
+		"name":         NewString(name),
+		"piece length": NewInt(pl),
+		"pieces":       NewBytes(pieces),
+	})
+	if st.Mode().IsRegular() {
+		info.Set("length", NewInt(total))
+	} else {
+		files := NewList()
+		for _, s := range srcs {
+			var ps []BNode
+			for _, c := range s.comps {
+				ps = append(ps, NewString(c))
+			}
+			files.Append(NewDict(map[string]BNode{
+				"length": NewInt(s.size),
+				"path":   NewList(ps...),
+			}))
+		}
+		info.Set("files", files)
+	}
+	if opts.Private {
+		info.Set("private", NewInt(1))
+	}
+	if opts.Source != "" {
+		info.Set("source", NewString(opts.Source))
+	}
+
+	t := NewTorrent(info.Map)
+	t.SetTrackers(opts.Trackers)
+	for _, u := range opts.WebSeeds {
+		t.AddWebSeed(u)
+	}
+	t.SetComment(opts.Comment)
+	t.SetCreatedBy(opts.CreatedBy)
+	t.SetCreationDate(opts.CreationDate)
+	return t, nil
+}
@@ -0,0 +1,74 @@
+package bencode
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCreateTorrent(t *testing.T) {
+	dir := t.TempDir()
+	files := []testFile{
+		{[]string{"b.bin"}, patternData(50000, 1)},
+		{[]string{"a", "c.txt"}, patternData(7000, 2)},
+		{[]string{"a", "empty"}, nil},
+	}
+	writeTestFiles(t, dir, "set", files)
+
+	tr, err := CreateTorrent(filepath.Join(dir, "set"), CreateOptions{
+		PieceLength: 16 * 1024,
+		Private:     true,
+		Trackers:    [][]string{{"http://t/announce"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err = ParseTorrent(tr.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.GetFileList(); !reflect.DeepEqual(got, []string{"a/c.txt", "a/empty", "b.bin"}) {
+		t.Fatalf("files %v", got)
+	}
+	if tr.Name() != "set" || tr.TotalLength() != 57000 || tr.PieceCount() != 4 || tr.Info()["private"].AsInt() != 1 {
+		t.Fatalf("%v %v %v", tr.Name(), tr.TotalLength(), tr.PieceCount())
+	}
+	s := NewStorage(tr, dir)
+	defer s.Close()
+	if have := s.Verify(); have.Count() != tr.PieceCount() {
+		t.Fatalf("verified %v/%v", have.Count(), tr.PieceCount())
+	}
+
+	single, err := CreateTorrent(filepath.Join(dir, "set", "b.bin"), CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if single.IsMultiFile() || single.Name() != "b.bin" || single.PieceLength() != MinPieceLength {
+		t.Fatalf("single file: %v %v", single.Name(), single.PieceLength())
+	}
+
+	// "." takes the name of the working directory
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	if err := os.Chdir(filepath.Join(dir, "set")); err != nil {
+		t.Fatal(err)
+	}
+	here, err := CreateTorrent(".", CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if here.Name() != "set" || here.CheckPaths() != nil {
+		t.Fatalf("created from .: name %q, %v", here.Name(), here.CheckPaths())
+	}
+	os.Chdir(wd)
+
+	os.Mkdir(filepath.Join(dir, "none"), 0755)
+	if _, err := CreateTorrent(filepath.Join(dir, "none"), CreateOptions{}); !errors.Is(err, EmptyTorrentError) {
+		t.Fatalf("empty dir: %v", err)
+	}
+	if DefaultPieceLength(1<<40) != MaxPieceLength {
+		t.Fatal("piece length not capped")
+	}
+}
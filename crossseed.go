@@ -0,0 +1,189 @@
+package bencode
+
+import (
+	"bytes"
+	"log"
+	"os"
+)
+
+// CrossSeedFile is one torrent entry and the library file chosen for it.
+type CrossSeedFile struct {
+	Entry FileEntry
+	Path  string // empty when nothing in the library fits
+
+	Candidates []Candidate
+
+	// several candidates remain and no piece tells them apart
+	Ambiguous bool
+}
+
+type CrossSeedReport struct {
+	Files []CrossSeedFile
+
+	// bytes of the entries a library file was found for
+	Matched int64
+	Total   int64
+
+	// pieces that fail when read from the chosen files, or that touch
+	// an entry with no file at all
+	Unsatisfiable []int
+	PieceCount    int
+}
+
+// Percent is the share of the pieces that verify from the library.
+func (r CrossSeedReport) Percent() float64 {
+	if r.PieceCount == 0 {
+		return 0
+	}
+	return 100 * float64(r.PieceCount-len(r.Unsatisfiable)) / float64(r.PieceCount)
+}
+
+func (r CrossSeedReport) Complete() bool {
+	return r.PieceCount > 0 && len(r.Unsatisfiable) == 0
+}
+
+// Paths maps entry indexes to the chosen library files.
+func (r CrossSeedReport) Paths() map[int]string {
+	rvs := make(map[int]string)
+	for _, f := range r.Files {
+		if f.Path != "" {
+			rvs[f.Entry.Index] = f.Path
+		}
+	}
+	return rvs
+}
+
+// FindCrossSeed looks for the content of t in the library roots.
+func FindCrossSeed(t *Torrent, roots ...string) CrossSeedReport {
+	return NewFileManWith(FileManOptions{}, roots...).CrossSeed(t)
+}
+
+// CrossSeed matches every entry of t against the indexed files, settles
+// ties by the pieces crossing into neighbouring files, then hashes every
+// piece from the chosen files.
+func (fm *FileMan) CrossSeed(t *Torrent) CrossSeedReport {
+	files := t.Files()
+	rep := CrossSeedReport{Total: t.TotalLength(), PieceCount: t.PieceCount()}
+	r := &entryReader{t: t, files: files, paths: make([]string, len(files)), open: make(map[int]*os.File)}
+	defer r.Close()
+
+	for i, fe := range files {
+		if fe.IsPadding() {
+			rep.Files = append(rep.Files, CrossSeedFile{Entry: fe})
+			continue
+		}
+		m := fm.Match(t, fe)
+		rep.Files = append(rep.Files, CrossSeedFile{
+			Entry:      fe,
+			Candidates: m.Candidates,
+			Ambiguous:  m.Ambiguous,
+		})
+		if len(m.Candidates) > 0 {
+			r.paths[i] = m.Candidates[0].Path
+		}
+	}
+
+	for i := range rep.Files {
+		cf := &rep.Files[i]
+		if !cf.Ambiguous {
+			continue
+		}
+		first, last := overlappingPieces(t, cf.Entry)
+		best, bestOK, tie := r.paths[i], -1, false
+		for _, c := range cf.Candidates {
+			r.set(i, c.Path)
+			ok := 0
+			for p := first; p < last; p++ {
+				if r.verify(p) {
+					ok++
+				}
+			}
+			switch {
+			case ok > bestOK:
+				best, bestOK, tie = c.Path, ok, false
+			case ok == bestOK:
+				tie = true
+			}
+		}
+		r.set(i, best)
+		cf.Ambiguous = tie
+	}
+
+	for i := 0; i < t.PieceCount(); i++ {
+		if !r.verify(i) {
+			rep.Unsatisfiable = append(rep.Unsatisfiable, i)
+		}
+	}
+	for i := range rep.Files {
+		cf := &rep.Files[i]
+		cf.Path = r.paths[i]
+		if cf.Path != "" || cf.Entry.Length == 0 || cf.Entry.IsPadding() {
+			rep.Matched += cf.Entry.Length
+		}
+	}
+	return rep
+}
+
+// overlappingPieces returns the pieces holding any byte of fe, as
+// [first, last).
+func overlappingPieces(t *Torrent, fe FileEntry) (int, int) {
+	if fe.Length == 0 {
+		return 0, 0
+	}
+	pl := t.PieceLength()
+	return int(fe.Offset / pl), int((fe.Offset+fe.Length-1)/pl) + 1
+}
+
+// entryReader reads torrent data out of files mapped per entry.
+type entryReader struct {
+	t     *Torrent
+	files []FileEntry // t.Files(), built once
+	paths []string
+	open  map[int]*os.File
+}
+
+func (r *entryReader) set(index int, p string) {
+	if f, ok := r.open[index]; ok {
+		f.Close()
+		delete(r.open, index)
+	}
+	r.paths[index] = p
+}
+
+func (r *entryReader) verify(index int) bool {
+	off := int64(index) * r.t.PieceLength()
+	buf := make([]byte, r.t.PieceSize(index))
+	spans, err := fileSpans(r.files, off, int64(len(buf)))
+	if err != nil {
+		return false
+	}
+	for _, sp := range spans {
+		i := sp.file.Index
+		if sp.file.IsPadding() {
+			// buf starts out zeroed
+			continue
+		}
+		if r.paths[i] == "" {
+			return false
+		}
+		f, ok := r.open[i]
+		if !ok {
+			if f, err = os.Open(r.paths[i]); err != nil {
+				log.Print(err)
+				return false
+			}
+			r.open[i] = f
+		}
+		if _, err := f.ReadAt(buf[sp.bufOff:sp.bufOff+sp.length], sp.fileOff); err != nil {
+			return false
+		}
+	}
+	return bytes.Equal(calcSha1Hash(buf), r.t.PieceHash(index))
+}
+
+func (r *entryReader) Close() {
+	for i, f := range r.open {
+		f.Close()
+		delete(r.open, i)
+	}
+}
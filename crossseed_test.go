@@ -0,0 +1,61 @@
+package bencode
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCrossSeed(t *testing.T) {
+	files := []testFile{
+		{[]string{"Show", "e01.mkv"}, patternData(50000, 1)},
+		{[]string{"Show", "info.nfo"}, patternData(100, 2)},
+		{[]string{"Show", "e02.mkv"}, patternData(70000, 3)},
+		{[]string{"Show", "e03.mkv"}, patternData(20000, 4)},
+	}
+	raw, _ := buildTestTorrent("Show", 16*1024, files)
+	tr, err := ParseTorrent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the library has its own names, a decoy nfo and no e03
+	lib := t.TempDir()
+	put := func(name string, data []byte) string {
+		p := filepath.ToSlash(filepath.Join(lib, name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	e01 := put("tv/show.s01e01.mkv", files[0].data)
+	put("tv/a/info.nfo", patternData(100, 7))
+	nfo := put("tv/b/info.nfo", files[1].data)
+	e02 := put("other/show.s01e02.mkv", files[2].data)
+
+	rep := FindCrossSeed(tr, lib)
+	want := map[int]string{0: e01, 1: nfo, 2: e02}
+	if got := rep.Paths(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("paths %v", got)
+	}
+	if rep.Files[1].Ambiguous {
+		t.Fatal("the spanning piece should settle the nfo")
+	}
+	// e03 covers 120100..140100 of the 16 KiB pieces
+	if !reflect.DeepEqual(rep.Unsatisfiable, []int{7, 8}) || rep.Complete() {
+		t.Fatalf("unsatisfiable %v", rep.Unsatisfiable)
+	}
+	if p := rep.Percent(); p < 77.7 || p > 77.8 {
+		t.Fatalf("percent %v", p)
+	}
+	if rep.Matched != 120100 || rep.Total != 140100 {
+		t.Fatalf("matched %v/%v", rep.Matched, rep.Total)
+	}
+
+	put("more/e03.mkv", files[3].data)
+	if rep := FindCrossSeed(tr, lib); !rep.Complete() || rep.Percent() != 100 {
+		t.Fatalf("complete library: %+v", rep.Unsatisfiable)
+	}
+}
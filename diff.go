@@ -0,0 +1,194 @@
+package bencode
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type ChangeKind int
+
+const (
+	DiffAdded ChangeKind = iota
+	DiffRemoved
+	DiffChanged
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case DiffAdded:
+		return "+"
+	case DiffRemoved:
+		return "-"
+	case DiffChanged:
+		return "~"
+	}
+	return "?"
+}
+
+// Change is one difference between two trees. Path is in the syntax of
+// Query; Old is unset for additions and New for removals.
+type Change struct {
+	Path string
+	Kind ChangeKind
+	Old  BNode
+	New  BNode
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case DiffAdded:
+		return fmt.Sprintf("+ %v: %v", c.Path, Summarize(c.New))
+	case DiffRemoved:
+		return fmt.Sprintf("- %v: %v", c.Path, Summarize(c.Old))
+	}
+	return fmt.Sprintf("~ %v: %v -> %v", c.Path, Summarize(c.Old), Summarize(c.New))
+}
+
+// AffectsInfoHash tells a change inside the info dictionary of a torrent.
+func (c Change) AffectsInfoHash() bool {
+	return c.Path == "info" || strings.HasPrefix(c.Path, "info.") || strings.HasPrefix(c.Path, "info[")
+}
+
+// Diff lists what changed from a to b: dictionaries by key, lists item by
+// item at the same index, anything else as a whole.
+func Diff(a, b BNode) []Change {
+	var rvs []Change
+	diffNodes("", a, b, &rvs)
+	return rvs
+}
+
+func diffNodes(at string, a, b BNode, rvs *[]Change) {
+	switch {
+	case a.Cat == BNodeMap && b.Cat == BNodeMap:
+		keys := make([]string, 0, len(a.Map)+len(b.Map))
+		for k := range a.Map {
+			keys = append(keys, k)
+		}
+		for k := range b.Map {
+			if _, ok := a.Map[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			av, inA := a.Map[k]
+			bv, inB := b.Map[k]
+			switch {
+			case !inA:
+				*rvs = append(*rvs, Change{Path: keyPath(at, k), Kind: DiffAdded, New: bv})
+			case !inB:
+				*rvs = append(*rvs, Change{Path: keyPath(at, k), Kind: DiffRemoved, Old: av})
+			default:
+				diffNodes(keyPath(at, k), av, bv, rvs)
+			}
+		}
+	case a.Cat == BNodeList && b.Cat == BNodeList:
+		for i := 0; i < len(a.List) || i < len(b.List); i++ {
+			p := at + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(a.List):
+				*rvs = append(*rvs, Change{Path: p, Kind: DiffAdded, New: b.List[i]})
+			case i >= len(b.List):
+				*rvs = append(*rvs, Change{Path: p, Kind: DiffRemoved, Old: a.List[i]})
+			default:
+				diffNodes(p, a.List[i], b.List[i], rvs)
+			}
+		}
+	case !a.Equal(b):
+		*rvs = append(*rvs, Change{Path: at, Kind: DiffChanged, Old: a, New: b})
+	}
+}
+
+// Summarize renders a node on one line: short text as it is, binary
+// strings by length and SHA-1, containers by size.
+func Summarize(n BNode) string {
+	switch {
+	case n.IsText():
+		s := n.AsString()
+		if len(s) > 64 {
+			return fmt.Sprintf("%q... (%v bytes)", s[:64], len(s))
+		}
+		return strconv.Quote(s)
+	case n.IsBytes():
+		sum := sha1.Sum(n.AsBinary())
+		return fmt.Sprintf("<%v bytes, sha1 %v>", len(n.AsBinary()), hex.EncodeToString(sum[:4]))
+	case n.Cat == BNodeInteger:
+		return strconv.FormatInt(*n.Int, 10)
+	case n.Cat == BNodeList:
+		return fmt.Sprintf("[%v items]", len(n.List))
+	case n.Cat == BNodeMap:
+		return fmt.Sprintf("{%v keys}", len(n.Map))
+	}
+	return "<none>"
+}
+
+// TorrentDiff tells the changes between two torrents apart by whether
+// they touch the info dictionary.
+type TorrentDiff struct {
+	Changes []Change
+
+	OldInfoHash [20]byte
+	NewInfoHash [20]byte
+}
+
+// InfoHashChanged compares the hashes themselves: re-encoding the same
+// info dictionary differently changes them even with no Changes in it.
+func (d TorrentDiff) InfoHashChanged() bool {
+	return d.OldInfoHash != d.NewInfoHash
+}
+
+// InfoChanges are the changes that make a different torrent.
+func (d TorrentDiff) InfoChanges() []Change {
+	var rvs []Change
+	for _, c := range d.Changes {
+		if c.AffectsInfoHash() {
+			rvs = append(rvs, c)
+		}
+	}
+	return rvs
+}
+
+// Cosmetic are the changes to the keys around info: trackers, comments
+// and the like.
+func (d TorrentDiff) Cosmetic() []Change {
+	var rvs []Change
+	for _, c := range d.Changes {
+		if !c.AffectsInfoHash() {
+			rvs = append(rvs, c)
+		}
+	}
+	return rvs
+}
+
+func (d TorrentDiff) Print(w io.Writer) {
+	if d.InfoHashChanged() {
+		fmt.Fprintf(w, "info-hash: %x -> %x\n", d.OldInfoHash, d.NewInfoHash)
+	} else {
+		fmt.Fprintf(w, "info-hash: %x (unchanged)\n", d.OldInfoHash)
+	}
+	for _, c := range d.InfoChanges() {
+		fmt.Fprintln(w, c)
+	}
+	for _, c := range d.Cosmetic() {
+		fmt.Fprintf(w, "%v (cosmetic)\n", c)
+	}
+}
+
+// DiffTorrents compares two torrents, the info dictionary included.
+func DiffTorrents(a, b *Torrent) TorrentDiff {
+	whole := func(t *Torrent) BNode {
+		n := NewDict(t.Meta())
+		n.Set("info", BNode{Map: t.Info(), Cat: BNodeMap})
+		return n
+	}
+	return TorrentDiff{
+		Changes:     Diff(whole(a), whole(b)),
+		OldInfoHash: a.InfoHash(),
+		NewInfoHash: b.InfoHash(),
+	}
+}
@@ -0,0 +1,56 @@
+package bencode
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := MustScanString("d8:announce3:t/14:listli1ei2ei3ee4:name1:x6:pieces3:\x00\x01\x02e")
+	b := MustScanString("d7:comment2:hi4:listli1ei5ee4:name1:y6:pieces3:\x00\x01\x03e")
+	var got []string
+	for _, c := range Diff(a, b) {
+		got = append(got, c.String())
+	}
+	want := []string{
+		`- announce: "t/1"`,
+		`+ comment: "hi"`,
+		`~ list[1]: 2 -> 5`,
+		`- list[2]: 3`,
+		`~ name: "x" -> "y"`,
+		`~ pieces: <3 bytes, sha1 0c7a623f> -> <3 bytes, sha1 e8ad5575>`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("diff:\n%v", strings.Join(got, "\n"))
+	}
+	if len(Diff(a, a.Clone())) != 0 {
+		t.Fatal("a clone differs")
+	}
+
+	raw, _ := buildTestTorrent("d", 16*1024, []testFile{
+		{[]string{"a"}, patternData(20000, 1)},
+		{[]string{"b"}, patternData(20000, 2)},
+	})
+	t1, _ := ParseTorrent(raw)
+	t2, _ := ParseTorrent(raw)
+	t2.Meta()["comment"] = NewString("re-saved")
+	d := DiffTorrents(t1, t2)
+	if d.InfoHashChanged() || len(d.InfoChanges()) != 0 || len(d.Cosmetic()) != 1 {
+		t.Fatalf("cosmetic edit: %+v", d)
+	}
+
+	t3, _ := ParseTorrent(raw)
+	info := NewDict(t3.Info())
+	info.Set("private", NewInt(1))
+	t3 = NewTorrent(info.Map)
+	d = DiffTorrents(t1, t3)
+	if !d.InfoHashChanged() || len(d.InfoChanges()) != 1 || d.InfoChanges()[0].Path != "info.private" {
+		t.Fatalf("info edit: %+v", d.Changes)
+	}
+	var buf bytes.Buffer
+	d.Print(&buf)
+	if !strings.Contains(buf.String(), "+ info.private: 1\n") {
+		t.Errorf("print:\n%v", buf.String())
+	}
+}
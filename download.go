@@ -0,0 +1,386 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// requests kept in flight per peer
+	DefaultPipeline = 16
+
+	// a peer sending this many pieces that fail the hash check is dropped
+	MaxHashFailures = 3
+)
+
+var (
+	IncompleteError = errors.New("download incomplete")
+)
+
+// DialFunc opens the transport to a peer, TCP unless configured otherwise.
+type DialFunc func(addr string, timeout time.Duration) (net.Conn, error)
+
+func dialTCP(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", addr, timeout)
+}
+
+// Downloader fetches the missing pieces of a torrent from a set of peers.
+// Every piece is checked against its SHA-1 before it touches the disk.
+type Downloader struct {
+	Torrent  *Torrent
+	Storage  *Storage
+	PeerID   [20]byte
+	Peers    []string
+	Pipeline int
+	Timeout  time.Duration
+	Dial     DialFunc
+
+	// a prior verification result; nil verifies what is on disk first
+	Resume Bitfield
+
+	// when set, only these pieces are fetched, whatever Resume says
+	Wanted []int
+
+	// optional, called after each hash check, from the peer goroutines
+	OnPiece func(index int, ok bool)
+
+	picker   *PiecePicker
+	mu       sync.Mutex
+	peers    map[*downloadPeer]bool
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+type downloadPeer struct {
+	d    *Downloader
+	pc   *PeerConn
+	addr string
+
+	mu         sync.Mutex
+	choked     bool
+	interested bool
+	theirs     Bitfield
+	inflight   map[BlockRequest]bool
+	hashFails  int
+}
+
+func NewDownloader(t *Torrent, dir string, peers []string) *Downloader {
+	return &Downloader{
+		Torrent:  t,
+		Storage:  NewStorage(t, dir),
+		PeerID:   GeneratePeerID(),
+		Peers:    peers,
+		Pipeline: DefaultPipeline,
+		Timeout:  HandshakeTimeout,
+		Dial:     dialTCP,
+		peers:    make(map[*downloadPeer]bool),
+		done:     make(chan struct{}),
+	}
+}
+
+// Have returns the verified pieces so far.
+func (d *Downloader) Have() Bitfield {
+	if d.picker == nil {
+		return d.Resume.Clone()
+	}
+	return d.picker.Have()
+}
+
+// Run downloads until every piece is verified or no peer is left, then
+// creates the zero-length files.
+func (d *Downloader) Run() error {
+	have := d.Resume
+	if have == nil {
+		have = d.Storage.Verify()
+	}
+	d.picker = NewPiecePicker(pieceSizes(d.Torrent), have)
+	if d.Wanted != nil {
+		d.picker.SetWanted(d.Wanted)
+	}
+	return d.run()
+}
+
+func (d *Downloader) run() error {
+	defer d.Storage.Close()
+	if d.picker.Done() {
+		return d.Storage.CreateEmpty()
+	}
+
+	var wg sync.WaitGroup
+	for _, addr := range d.Peers {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			if err := d.runPeer(addr); err != nil && err != io.EOF {
+				select {
+				case <-d.done:
+				default:
+					log.Printf("peer %v: %v", addr, err)
+				}
+			}
+		}(addr)
+	}
+	allGone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(allGone)
+	}()
+
+	select {
+	case <-d.done:
+		d.mu.Lock()
+		for p := range d.peers {
+			p.pc.Close()
+		}
+		d.mu.Unlock()
+		<-allGone
+	case <-allGone:
+	}
+
+	if !d.picker.Done() {
+		have := d.picker.Have()
+		return fmt.Errorf("%w: %v/%v piece(s)", IncompleteError, have.Count(), d.Torrent.PieceCount())
+	}
+	return d.Storage.CreateEmpty()
+}
+
+// Stop closes every peer connection; Run returns soon after.
+func (d *Downloader) Stop() {
+	d.doneOnce.Do(func() { close(d.done) })
+}
+
+func (d *Downloader) runPeer(addr string) error {
+	conn, err := d.Dial(addr, d.Timeout)
+	if err != nil {
+		return err
+	}
+	h := Handshake{InfoHash: d.Torrent.InfoHash(), PeerID: d.PeerID}
+	pc, _, err := InitiateHandshake(conn, h, d.Timeout)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer pc.Close()
+	pc.ReadTimeout = IdleTimeout
+	pc.WriteTimeout = IdleTimeout
+
+	p := &downloadPeer{
+		d:        d,
+		pc:       pc,
+		addr:     addr,
+		choked:   true,
+		theirs:   NewBitfield(d.Torrent.PieceCount()),
+		inflight: make(map[BlockRequest]bool),
+	}
+	d.mu.Lock()
+	select {
+	case <-d.done:
+		d.mu.Unlock()
+		return nil
+	default:
+	}
+	d.peers[p] = true
+	d.mu.Unlock()
+	defer d.dropPeer(p)
+
+	if have := d.picker.Have(); have.Count() > 0 {
+		if err := pc.WriteMessage(BitfieldMessage(have)); err != nil {
+			return err
+		}
+	}
+	for {
+		m, err := pc.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if err := p.handle(m); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *Downloader) dropPeer(p *downloadPeer) {
+	d.mu.Lock()
+	delete(d.peers, p)
+	d.mu.Unlock()
+
+	p.mu.Lock()
+	d.picker.PeerGone(p.theirs)
+	for req := range p.inflight {
+		d.picker.Cancel(req)
+	}
+	p.inflight = nil
+	p.mu.Unlock()
+	d.pokeAll()
+}
+
+func (d *Downloader) eachPeer(f func(p *downloadPeer)) {
+	d.mu.Lock()
+	var peers []*downloadPeer
+	for p := range d.peers {
+		peers = append(peers, p)
+	}
+	d.mu.Unlock()
+	for _, p := range peers {
+		f(p)
+	}
+}
+
+// pokeAll lets idle peers pick up blocks that went back into play.
+func (d *Downloader) pokeAll() {
+	d.eachPeer(func(p *downloadPeer) {
+		p.updateInterest()
+		p.fill()
+	})
+}
+
+func (p *downloadPeer) handle(m Message) error {
+	d := p.d
+	if m.KeepAlive {
+		return nil
+	}
+	switch m.ID {
+	case MsgBitfield:
+		bf := Bitfield(m.Bitfield)
+		if err := bf.Validate(d.Torrent.PieceCount()); err != nil {
+			return err
+		}
+		p.mu.Lock()
+		p.theirs = bf.Clone()
+		p.mu.Unlock()
+		d.picker.PeerHas(bf)
+		p.updateInterest()
+	case MsgHave:
+		if int(m.Index) >= d.Torrent.PieceCount() {
+			return fmt.Errorf("%w: %v", MessageFormatError, m)
+		}
+		p.mu.Lock()
+		p.theirs.Set(int(m.Index))
+		p.mu.Unlock()
+		d.picker.PeerHave(int(m.Index))
+		p.updateInterest()
+	case MsgUnchoke:
+		p.mu.Lock()
+		p.choked = false
+		p.mu.Unlock()
+	case MsgChoke:
+		p.mu.Lock()
+		p.choked = true
+		for req := range p.inflight {
+			d.picker.Cancel(req)
+		}
+		p.inflight = make(map[BlockRequest]bool)
+		p.mu.Unlock()
+		d.pokeAll()
+	case MsgPiece:
+		req := BlockRequest{Index: m.Index, Begin: m.Begin, Length: uint32(len(m.Block))}
+		p.mu.Lock()
+		delete(p.inflight, req)
+		p.mu.Unlock()
+		data, complete := d.picker.Received(int(m.Index), int(m.Begin), m.Block)
+		d.cancelElsewhere(req, p)
+		if complete {
+			if err := d.finishPiece(int(m.Index), data, p); err != nil {
+				return err
+			}
+		}
+	}
+	p.fill()
+	return nil
+}
+
+func (p *downloadPeer) updateInterest() {
+	p.mu.Lock()
+	theirs := p.theirs.Clone()
+	p.mu.Unlock()
+	interesting := p.d.picker.Interesting(theirs)
+
+	p.mu.Lock()
+	changed := interesting != p.interested
+	p.interested = interesting
+	p.mu.Unlock()
+	if !changed {
+		return
+	}
+	if interesting {
+		p.pc.WriteMessage(InterestedMessage())
+	} else {
+		p.pc.WriteMessage(NotInterestedMessage())
+	}
+}
+
+func (p *downloadPeer) fill() {
+	p.mu.Lock()
+	if p.choked || p.inflight == nil {
+		p.mu.Unlock()
+		return
+	}
+	want := p.d.Pipeline - len(p.inflight)
+	reqs := p.d.picker.Pick(p.theirs, want, p.inflight)
+	for _, req := range reqs {
+		p.inflight[req] = true
+	}
+	p.mu.Unlock()
+	for _, req := range reqs {
+		if err := p.pc.WriteMessage(req.Message()); err != nil {
+			return
+		}
+	}
+}
+
+// cancelElsewhere withdraws endgame duplicates of a block that just arrived.
+func (d *Downloader) cancelElsewhere(req BlockRequest, from *downloadPeer) {
+	d.eachPeer(func(q *downloadPeer) {
+		if q == from {
+			return
+		}
+		q.mu.Lock()
+		dup := q.inflight[req]
+		delete(q.inflight, req)
+		q.mu.Unlock()
+		if dup {
+			q.pc.WriteMessage(req.CancelMessage())
+		}
+	})
+}
+
+func (d *Downloader) finishPiece(index int, data []byte, from *downloadPeer) error {
+	ok := bytes.Equal(calcSha1Hash(data), d.Torrent.PieceHash(index))
+	if ok {
+		if err := d.Storage.WritePiece(index, data); err != nil {
+			log.Printf("writing piece %v: %v", index, err)
+			ok = false
+		}
+	}
+	if d.OnPiece != nil {
+		d.OnPiece(index, ok)
+	}
+	if !ok {
+		d.picker.PieceFailed(index)
+		from.mu.Lock()
+		from.hashFails++
+		fails := from.hashFails
+		from.mu.Unlock()
+		d.pokeAll()
+		if fails >= MaxHashFailures {
+			return fmt.Errorf("%v piece(s) failed the hash check", fails)
+		}
+		return nil
+	}
+
+	d.picker.PieceVerified(index)
+	d.eachPeer(func(q *downloadPeer) {
+		q.pc.WriteMessage(HaveMessage(uint32(index)))
+		q.updateInterest()
+	})
+	if d.picker.Done() {
+		d.Stop()
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package bencode
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startTestSeeder(t *testing.T, tr *Torrent, dir string) string {
+	s := NewSeeder()
+	t.Cleanup(func() { s.Close() })
+	if _, err := s.AddTorrent(tr, dir); err != nil {
+		t.Fatal(err)
+	}
+	addr, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr.String()
+}
+
+func checkTestFiles(t *testing.T, dir, name string, files []testFile) {
+	for _, f := range files {
+		p := filepath.Join(append([]string{dir, name}, f.path...)...)
+		got, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, f.data) {
+			t.Fatalf("%v differs", p)
+		}
+	}
+}
+
+func TestDownloadFromSeeders(t *testing.T) {
+	files := []testFile{
+		{[]string{"a.bin"}, patternData(100000, 1)},
+		{[]string{"empty"}, nil},
+		{[]string{"sub", "b.bin"}, patternData(77777, 2)},
+	}
+	raw, _ := buildTestTorrent("dl", 32*1024, files)
+	tr, err := ParseTorrent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedDir := t.TempDir()
+	writeTestFiles(t, seedDir, "dl", files)
+	addrs := []string{startTestSeeder(t, tr, seedDir), startTestSeeder(t, tr, seedDir)}
+
+	dir := t.TempDir()
+	d := NewDownloader(tr, dir, addrs)
+	d.Timeout = 5 * time.Second
+	var verified int32
+	d.OnPiece = func(index int, ok bool) {
+		if ok {
+			atomic.AddInt32(&verified, 1)
+		}
+	}
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if d.Have().Count() != tr.PieceCount() {
+		t.Fatalf("have %v/%v", d.Have().Count(), tr.PieceCount())
+	}
+	checkTestFiles(t, dir, "dl", files)
+	if atomic.LoadInt32(&verified) == 0 {
+		t.Fatalf("OnPiece never called")
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	files := []testFile{
+		{[]string{"a.bin"}, patternData(64*1024, 5)},
+		{[]string{"b.bin"}, patternData(64*1024, 6)},
+	}
+	raw, _ := buildTestTorrent("resume", 32*1024, files)
+	tr, _ := ParseTorrent(raw)
+	seedDir := t.TempDir()
+	writeTestFiles(t, seedDir, "resume", files)
+	addr := startTestSeeder(t, tr, seedDir)
+
+	// the first file is already there
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "resume", files[:1])
+	resume := NewBitfield(tr.PieceCount())
+	resume.Set(0)
+	resume.Set(1)
+
+	var fetched []int
+	d := NewDownloader(tr, dir, []string{addr})
+	d.Resume = resume
+	d.OnPiece = func(index int, ok bool) {
+		fetched = append(fetched, index)
+	}
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range fetched {
+		if i < 2 {
+			t.Fatalf("piece %v was in the resume bitfield", i)
+		}
+	}
+	checkTestFiles(t, dir, "resume", files)
+
+	// nothing left to do
+	d = NewDownloader(tr, dir, nil)
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPiecePicker(t *testing.T) {
+	sizes := []int64{BlockSize * 2, BlockSize * 2, BlockSize}
+	pp := NewPiecePicker(sizes, NewBitfield(3))
+	all := Bitfield{0xe0}
+	rare := Bitfield{0x20}
+	pp.PeerHas(all)
+	pp.PeerHas(all)
+	pp.PeerHas(rare)
+	pp.avail[0] = 5
+
+	// piece 1 and 2 both have 2 peers, piece 0 has 5
+	reqs := pp.Pick(all, 1, nil)
+	if len(reqs) != 1 || reqs[0].Index == 0 {
+		t.Fatalf("rarest first: %+v", reqs)
+	}
+	first := reqs[0]
+
+	// everything else
+	inflight := map[BlockRequest]bool{first: true}
+	for _, r := range pp.Pick(all, 10, inflight) {
+		inflight[r] = true
+	}
+	if len(inflight) != 5 || !pp.Endgame() {
+		t.Fatalf("expect endgame with 5 block(s) out, got %v", len(inflight))
+	}
+	// another peer gets duplicates now
+	dups := pp.Pick(all, 10, nil)
+	if len(dups) != 5 {
+		t.Fatalf("endgame duplicates: %+v", dups)
+	}
+
+	data, complete := pp.Received(2, 0, make([]byte, BlockSize))
+	if !complete || len(data) != BlockSize {
+		t.Fatalf("piece 2 shall be complete")
+	}
+	pp.PieceFailed(2)
+	if pp.Endgame() {
+		t.Fatalf("a failed piece goes back into play")
+	}
+	if reqs := pp.Pick(rare, 10, nil); len(reqs) != 1 || reqs[0].Index != 2 {
+		t.Fatalf("expect piece 2 again, got %+v", reqs)
+	}
+	if _, complete := pp.Received(2, 0, make([]byte, BlockSize)); !complete {
+		t.Fatalf("piece 2 shall be complete again")
+	}
+	if _, complete := pp.Received(2, 0, make([]byte, BlockSize)); complete {
+		t.Fatalf("a piece leaves the picker once complete")
+	}
+	pp.PieceVerified(2)
+	if !pp.Have().Has(2) || pp.Done() {
+		t.Fatalf("have: %x", []byte(pp.Have()))
+	}
+}
@@ -0,0 +1,145 @@
+package bencode
+
+// Editing the keys around the info dictionary. None of these touch info,
+// so Bytes writes the original info bytes back and the info-hash holds;
+// SetInfoKey is the one way into info, and it says so.
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	InfoKeyError        = errors.New("key belongs in the info dictionary")
+	InfoHashChangeError = errors.New("edit changes the info-hash")
+)
+
+// keys clients read from info only; set at the top level they do nothing
+var infoOnlyKeys = map[string]bool{
+	"info":         true,
+	"name":         true,
+	"piece length": true,
+	"pieces":       true,
+	"length":       true,
+	"files":        true,
+	"private":      true,
+	"source":       true,
+	"meta version": true,
+	"file tree":    true,
+}
+
+// SetMeta sets a top-level key.
+func (t *Torrent) SetMeta(key string, v BNode) error {
+	if infoOnlyKeys[key] {
+		return fmt.Errorf("%w: %q", InfoKeyError, key)
+	}
+	t.Meta()[key] = v
+	return nil
+}
+
+// DeleteMeta removes a top-level key and tells whether it was there.
+func (t *Torrent) DeleteMeta(key string) bool {
+	meta := t.Meta()
+	_, ok := meta[key]
+	delete(meta, key)
+	return ok
+}
+
+// SetTrackers replaces announce and announce-list with the given tiers;
+// announce is the first tracker. No tiers removes both.
+func (t *Torrent) SetTrackers(tiers [][]string) {
+	var list []BNode
+	for _, tier := range tiers {
+		var urls []BNode
+		for _, u := range tier {
+			if u != "" {
+				urls = append(urls, NewString(u))
+			}
+		}
+		if len(urls) > 0 {
+			list = append(list, NewList(urls...))
+		}
+	}
+	t.DeleteMeta("announce")
+	t.DeleteMeta("announce-list")
+	if len(list) == 0 {
+		return
+	}
+	t.Meta()["announce"] = list[0].List[0]
+	if len(list) > 1 || len(list[0].List) > 1 {
+		t.Meta()["announce-list"] = NewList(list...)
+	}
+}
+
+// AddWebSeed appends a url-list entry (BEP 19), turning a single url
+// into a list. It tells whether the url was new.
+func (t *Torrent) AddWebSeed(u string) bool {
+	for _, ws := range t.WebSeeds() {
+		if !ws.HTTPSeed && ws.URL == u {
+			return false
+		}
+	}
+	meta := t.Meta()
+	ul, ok := meta["url-list"]
+	switch {
+	case !ok:
+		ul = NewList()
+	case ul.IsBytes():
+		ul = NewList(ul)
+	}
+	ul.Append(NewString(u))
+	meta["url-list"] = ul
+	return true
+}
+
+// SetComment sets the comment; an empty one removes it.
+func (t *Torrent) SetComment(s string) {
+	t.setOptionalString("comment", s)
+}
+
+func (t *Torrent) SetCreatedBy(s string) {
+	t.setOptionalString("created by", s)
+}
+
+// SetCreationDate sets the creation date; the zero time removes it.
+func (t *Torrent) SetCreationDate(tm time.Time) {
+	if tm.IsZero() {
+		t.DeleteMeta("creation date")
+		return
+	}
+	t.Meta()["creation date"] = NewInt(tm.Unix())
+}
+
+func (t *Torrent) setOptionalString(key, s string) {
+	if s == "" {
+		t.DeleteMeta(key)
+		return
+	}
+	t.Meta()[key] = NewString(s)
+}
+
+// SetInfoKey sets key in the info dictionary, or removes it when v is
+// nil. As that makes a different torrent, it is refused with
+// InfoHashChangeError unless force is set; the error names both hashes.
+// A forced edit re-encodes info canonically.
+func (t *Torrent) SetInfoKey(key string, v *BNode, force bool) error {
+	old, ok := t.info[key]
+	if (v == nil && !ok) || (v != nil && ok && old.Equal(*v)) {
+		return nil
+	}
+	info := NewDict(t.info)
+	if v == nil {
+		info.Delete(key)
+	} else {
+		info.Set(key, *v)
+	}
+	before := t.InfoHash()
+	after := (&Torrent{info: info.Map}).InfoHash()
+	if !force {
+		return fmt.Errorf("%w: %q: %x -> %x", InfoHashChangeError, key, before, after)
+	}
+	t.info = info.Map
+	t.rawInfo = nil
+	return nil
+}
@@ -0,0 +1,63 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEditKeepsInfoHash(t *testing.T) {
+	// info keys out of canonical order: re-encoding would change the hash
+	info := "d4:name1:x12:piece lengthi16384e6:lengthi3e6:pieces20:" + strings.Repeat("\x01", 20) + "e"
+	raw := []byte("d8:announce3:t/113:creation datei1700000000e4:info" + info + "e")
+	tr, err := ParseTorrent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := tr.InfoHash()
+
+	tr.SetTrackers([][]string{{"t/a", "t/b"}, {"t/c"}})
+	if !tr.AddWebSeed("http://w/") || tr.AddWebSeed("http://w/") {
+		t.Fatal("web seed added twice")
+	}
+	tr.SetComment("hello")
+	tr.SetCreationDate(time.Time{})
+	if err := tr.SetMeta("private", NewInt(1)); !errors.Is(err, InfoKeyError) {
+		t.Fatalf("top-level private: %v", err)
+	}
+	one := NewInt(1)
+	if err := tr.SetInfoKey("private", &one, false); !errors.Is(err, InfoHashChangeError) {
+		t.Fatalf("unforced info edit: %v", err)
+	}
+
+	edited, err := ParseTorrent(tr.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.InfoHash() != hash || !bytes.Equal(edited.RawInfo(), []byte(info)) {
+		t.Fatal("info dictionary changed")
+	}
+	if got := edited.Announces(); !reflect.DeepEqual(got, []string{"t/a", "t/b", "t/c"}) {
+		t.Errorf("trackers %v", got)
+	}
+	if _, ok := edited.Meta()["creation date"]; ok || edited.Meta()["comment"].AsString() != "hello" {
+		t.Errorf("meta %v", edited.Meta())
+	}
+	if ws := edited.WebSeeds(); len(ws) != 1 || ws[0].URL != "http://w/" {
+		t.Errorf("web seeds %v", ws)
+	}
+
+	if err := tr.SetInfoKey("private", &one, true); err != nil {
+		t.Fatal(err)
+	}
+	if tr.InfoHash() == hash || tr.Info()["private"].AsInt() != 1 {
+		t.Fatal("forced info edit not applied")
+	}
+	tr.SetTrackers(nil)
+	if len(tr.Announces()) != 0 {
+		t.Fatal("trackers left")
+	}
+}
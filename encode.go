@@ -0,0 +1,77 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Encode serializes the node in canonical form: dictionary keys are sorted.
+func Encode(node BNode) []byte {
+	var buf bytes.Buffer
+	encodeNode(&buf, node)
+	return buf.Bytes()
+}
+
+func EncodeTo(w io.Writer, node BNode) error {
+	_, err := w.Write(Encode(node))
+	return err
+}
+
+func encodeNode(buf *bytes.Buffer, node BNode) {
+	switch node.Cat {
+	case BNodeString:
+		encodeBytes(buf, []byte(*node.Str))
+	case BNodeInteger:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatInt(*node.Int, 10))
+		buf.WriteByte('e')
+	case BNodeList:
+		buf.WriteByte('l')
+		for _, el := range node.List {
+			encodeNode(buf, el)
+		}
+		buf.WriteByte('e')
+	case BNodeMap:
+		buf.WriteByte('d')
+		keys := make([]string, 0, len(node.Map))
+		for k := range node.Map {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			encodeBytes(buf, []byte(k))
+			encodeNode(buf, node.Map[k])
+		}
+		buf.WriteByte('e')
+	default:
+		panic(TypeError)
+	}
+}
+
+func encodeBytes(buf *bytes.Buffer, b []byte) {
+	buf.WriteString(strconv.Itoa(len(b)))
+	buf.WriteByte(':')
+	buf.Write(b)
+}
+
+// TryScan is Scan for untrusted input: a malformed or truncated document
+// is reported as an error instead of a panic.
+func TryScan(raw []byte) (node BNode, remains []byte, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			if ee, ok := e.(error); ok {
+				err = fmt.Errorf("%w: %v", GeneralFormatError, ee)
+			} else {
+				err = fmt.Errorf("%w: %v", GeneralFormatError, e)
+			}
+		}
+	}()
+	if len(raw) == 0 {
+		return node, raw, GeneralFormatError
+	}
+	node, remains = Scan(raw)
+	return
+}
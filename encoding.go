@@ -0,0 +1,104 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+var (
+	EncodingError = errors.New("unsupported encoding")
+)
+
+// code page names old clients wrote that the WHATWG labels lack
+var encodingAliases = map[string]encoding.Encoding{
+	"cp932": japanese.ShiftJIS,
+	"sjis":  japanese.ShiftJIS,
+	"cp936": simplifiedchinese.GBK,
+	"cp949": korean.EUCKR,
+}
+
+// LookupEncoding finds the decoder for an `encoding` value such as GBK,
+// Big5, Shift_JIS or CP1251. UTF-8 gives nil.
+func LookupEncoding(name string) (encoding.Encoding, error) {
+	label := strings.ToLower(strings.TrimSpace(name))
+	if label == "" || label == "utf-8" || label == "utf8" {
+		return nil, nil
+	}
+	if e, ok := encodingAliases[label]; ok {
+		return e, nil
+	}
+	e, err := htmlindex.Get(label)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", EncodingError, name)
+	}
+	if e == encoding.Nop {
+		return nil, nil
+	}
+	return e, nil
+}
+
+// Encoding is the declared encoding of the names, empty when none.
+func (t *Torrent) Encoding() string {
+	if e, ok := t.meta["encoding"]; ok && e.IsBytes() {
+		return e.AsString()
+	}
+	return ""
+}
+
+// decodeName turns a raw name from the torrent into UTF-8 through the
+// declared encoding. Names that are UTF-8 already, or that do not decode,
+// are kept as they are; the decoders substitute U+FFFD for bytes they
+// cannot map, so that counts as not decoding.
+func (t *Torrent) decodeName(raw string) string {
+	if utf8.ValidString(raw) {
+		return raw
+	}
+	e, err := LookupEncoding(t.Encoding())
+	if err != nil || e == nil {
+		return raw
+	}
+	s, err := e.NewDecoder().String(raw)
+	if err != nil || strings.ContainsRune(s, utf8.RuneError) {
+		return raw
+	}
+	return s
+}
+
+// utf8Alternate returns the `key.utf-8` string, if a valid one is present.
+func utf8Alternate(m map[string]BNode, key string) (string, bool) {
+	if n, ok := m[key+".utf-8"]; ok && n.IsBytes() && utf8.ValidString(n.AsString()) {
+		return n.AsString(), true
+	}
+	return "", false
+}
+
+func utf8PathAlternate(m map[string]BNode) ([]string, bool) {
+	n, ok := m["path.utf-8"]
+	if !ok || n.Cat != BNodeList || len(n.List) == 0 {
+		return nil, false
+	}
+	var rvs []string
+	for _, p := range n.List {
+		if !p.IsBytes() || !utf8.ValidString(p.AsString()) {
+			return nil, false
+		}
+		rvs = append(rvs, p.AsString())
+	}
+	return rvs, true
+}
+
+// RawName is the name exactly as stored in the torrent.
+func (t *Torrent) RawName() []byte {
+	if n, ok := t.info["name"]; ok && n.IsBytes() {
+		return n.AsBinary()
+	}
+	return nil
+}
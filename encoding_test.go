@@ -0,0 +1,108 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+func encodedTorrent(t *testing.T, enc, name string, paths [][]string, utf8Paths map[int][]string) *Torrent {
+	var files []BNode
+	for i, ps := range paths {
+		var list []BNode
+		for _, p := range ps {
+			list = append(list, NewString(p))
+		}
+		fi := map[string]BNode{"length": NewInt(10), "path": {List: list, Cat: BNodeList}}
+		if alt, ok := utf8Paths[i]; ok {
+			var l []BNode
+			for _, p := range alt {
+				l = append(l, NewString(p))
+			}
+			fi["path.utf-8"] = BNode{List: l, Cat: BNodeList}
+		}
+		files = append(files, BNode{Map: fi, Cat: BNodeMap})
+	}
+	info := map[string]BNode{
+		"name":         NewString(name),
+		"piece length": NewInt(16384),
+		"pieces":       NewBytes(make([]byte, 20)),
+		"files":        {List: files, Cat: BNodeList},
+	}
+	top := map[string]BNode{"info": {Map: info, Cat: BNodeMap}}
+	if enc != "" {
+		top["encoding"] = NewString(enc)
+	}
+	tr, err := ParseTorrent(Encode(BNode{Map: top, Cat: BNodeMap}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func TestTorrentEncodings(t *testing.T) {
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().String("中文资料")
+	big5, _ := traditionalchinese.Big5.NewEncoder().String("繁體")
+	sjis, _ := japanese.ShiftJIS.NewEncoder().String("日本語.txt")
+	cyr, _ := charmap.Windows1251.NewEncoder().String("Привет")
+
+	tr := encodedTorrent(t, "GBK", gbk, [][]string{{gbk, "a.txt"}, {"raw"}}, map[int][]string{1: {"备用.txt"}})
+	if tr.Name() != "中文资料" || !bytes.Equal(tr.RawName(), []byte(gbk)) {
+		t.Fatalf("name %q", tr.Name())
+	}
+	files := tr.Files()
+	if files[0].Path[0] != "中文资料" || string(files[0].RawPath[0]) != gbk || files[0].Path[1] != "a.txt" {
+		t.Fatalf("path %q", files[0].Path)
+	}
+	if files[1].Path[0] != "备用.txt" || string(files[1].RawPath[0]) != "raw" {
+		t.Fatalf("path.utf-8 %q", files[1].Path)
+	}
+	if got := tr.GetFileList(); !reflect.DeepEqual(got, []string{"中文资料/a.txt", "备用.txt"}) {
+		t.Fatalf("file list %q", got)
+	}
+	// VerifyAll looks files up by the same names
+	for i, fi := range tr.info["files"].AsList() {
+		if got := tr.toPathName(fi.AsMap()); got != strings.Join(files[i].Path, "/") {
+			t.Fatalf("verify path %q, files path %q", got, files[i].Path)
+		}
+	}
+
+	for enc, c := range map[string][2]string{
+		"Big5":         {big5, "繁體"},
+		"Shift_JIS":    {sjis, "日本語.txt"},
+		"CP932":        {sjis, "日本語.txt"},
+		"CP1251":       {cyr, "Привет"},
+		"windows-1251": {cyr, "Привет"},
+	} {
+		tr := encodedTorrent(t, enc, c[0], [][]string{{c[0]}}, nil)
+		if tr.Name() != c[1] || tr.Files()[0].Path[0] != c[1] {
+			t.Errorf("%v: %q", enc, tr.Name())
+		}
+	}
+
+	// name.utf-8 wins over the declared encoding
+	tr = encodedTorrent(t, "GBK", gbk, [][]string{{"x"}}, nil)
+	tr.info["name.utf-8"] = NewString("显示名")
+	if tr.Name() != "显示名" {
+		t.Fatalf("name.utf-8: %q", tr.Name())
+	}
+
+	// no declaration leaves the bytes alone
+	if tr := encodedTorrent(t, "", gbk, [][]string{{"x"}}, nil); tr.Name() != gbk {
+		t.Fatalf("undeclared: %q", tr.Name())
+	}
+	// names that are UTF-8 already, or that do not decode, are kept
+	if tr := encodedTorrent(t, "GBK", "中文", [][]string{{"\xff\xff"}}, nil); tr.Name() != "中文" || tr.Files()[0].Path[0] != "\xff\xff" {
+		t.Fatalf("kept: %q %q", tr.Name(), tr.Files()[0].Path)
+	}
+	if _, err := LookupEncoding("klingon"); !errors.Is(err, EncodingError) {
+		t.Fatalf("expect EncodingError, got %v", err)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
-	"strings"
 	"time"
 
 	"github.com/eminom/gobencode"
@@ -62,12 +61,9 @@ func main() {
 	t := bencode.NewTorrent(node.AsMap()["info"].AsMap())
 	t.PrintSummary()
 
+	// padding files are left out of the list
 	for _, filename := range t.GetFileList() {
-		if !strings.HasPrefix(filename, "_____padding_file") {
-			verifyOne(t, filename)
-		} else {
-			log.Printf("skipping %v", filename)
-		}
+		verifyOne(t, filename)
 	}
 }
 
@@ -0,0 +1,341 @@
+package bencode
+
+// Extension protocol
+// http://bittorrent.org/beps/bep_0010.html
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+)
+
+const (
+	MsgExtended = 20
+
+	// extended message id reserved for the extended handshake
+	ExtHandshakeID = 0
+)
+
+var (
+	ExtensionNotSupportedError = errors.New("extension not supported by peer")
+	ExtensionRegisteredError   = errors.New("extension already registered")
+	ExtensionHandshakeError    = errors.New("invalid extended handshake")
+)
+
+// SetExtensionProtocol marks reserved bit 20 (counted from the right).
+func (h *Handshake) SetExtensionProtocol() {
+	h.Reserved[5] |= 0x10
+}
+
+func (h Handshake) SupportsExtensionProtocol() bool {
+	return h.Reserved[5]&0x10 != 0
+}
+
+func ExtendedMessage(extID byte, payload []byte) Message {
+	return Message{ID: MsgExtended, Payload: append([]byte{extID}, payload...)}
+}
+
+type ExtendedHandshake struct {
+	// extension name to message id, 0 means disabled
+	M map[string]int64
+
+	V            string
+	YourIP       net.IP
+	Port         int64
+	Reqq         int64
+	MetadataSize int64
+
+	// keys not interpreted above, kept so nothing is lost on re-encoding
+	Extra map[string]BNode
+}
+
+func (h ExtendedHandshake) Encode() []byte {
+	m := make(map[string]BNode)
+	for k, v := range h.Extra {
+		m[k] = v
+	}
+	ids := make(map[string]BNode)
+	for name, id := range h.M {
+		ids[name] = NewInt(id)
+	}
+	m["m"] = BNode{Map: ids, Cat: BNodeMap}
+	if h.V != "" {
+		m["v"] = NewString(h.V)
+	}
+	if h.YourIP != nil {
+		ip := h.YourIP.To4()
+		if ip == nil {
+			ip = h.YourIP.To16()
+		}
+		m["yourip"] = NewBytes(ip)
+	}
+	if h.Port > 0 {
+		m["p"] = NewInt(h.Port)
+	}
+	if h.Reqq > 0 {
+		m["reqq"] = NewInt(h.Reqq)
+	}
+	if h.MetadataSize > 0 {
+		m["metadata_size"] = NewInt(h.MetadataSize)
+	}
+	return Encode(BNode{Map: m, Cat: BNodeMap})
+}
+
+func ParseExtendedHandshake(payload []byte) (ExtendedHandshake, error) {
+	var h ExtendedHandshake
+	node, _, err := TryScan(payload)
+	if err != nil {
+		return h, err
+	}
+	if node.Cat != BNodeMap {
+		return h, ExtensionHandshakeError
+	}
+	h.M = make(map[string]int64)
+	h.Extra = make(map[string]BNode)
+	for k, v := range node.Map {
+		switch {
+		case k == "m" && v.Cat == BNodeMap:
+			for name, id := range v.Map {
+				if id.Cat == BNodeInteger {
+					h.M[name] = *id.Int
+				}
+			}
+		case k == "v" && v.IsBytes():
+			h.V = v.AsString()
+		case k == "yourip" && v.IsBytes():
+			if ip := v.AsString(); len(ip) == net.IPv4len || len(ip) == net.IPv6len {
+				h.YourIP = net.IP(ip)
+			}
+		case k == "p" && v.Cat == BNodeInteger:
+			h.Port = *v.Int
+		case k == "reqq" && v.Cat == BNodeInteger:
+			h.Reqq = *v.Int
+		case k == "metadata_size" && v.Cat == BNodeInteger:
+			h.MetadataSize = *v.Int
+		default:
+			h.Extra[k] = v
+		}
+	}
+	return h, nil
+}
+
+// Extension is one named extension. Handle receives the payload of every
+// extended message the peer sends under the id we advertised for it.
+type Extension struct {
+	Name   string
+	Handle func(s *ExtensionSession, payload []byte) error
+
+	// optional, called once the peer's extended handshake arrives
+	OnHandshake func(s *ExtensionSession) error
+}
+
+// ExtensionRegistry assigns local message ids to extensions.
+// It is shared by all connections of a client.
+type ExtensionRegistry struct {
+	mu    sync.RWMutex
+	exts  map[string]Extension
+	ids   map[string]byte
+	names map[byte]string
+}
+
+func NewExtensionRegistry() *ExtensionRegistry {
+	return &ExtensionRegistry{
+		exts:  make(map[string]Extension),
+		ids:   make(map[string]byte),
+		names: make(map[byte]string),
+	}
+}
+
+func (r *ExtensionRegistry) Register(ext Extension) (byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.exts[ext.Name]; ok {
+		return 0, fmt.Errorf("%w: %v", ExtensionRegisteredError, ext.Name)
+	}
+	if len(r.exts) >= 255 {
+		return 0, fmt.Errorf("too many extensions")
+	}
+	id := byte(len(r.exts) + 1)
+	r.exts[ext.Name] = ext
+	r.ids[ext.Name] = id
+	r.names[id] = ext.Name
+	return id, nil
+}
+
+func (r *ExtensionRegistry) LocalID(name string) (byte, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	id, ok := r.ids[name]
+	return id, ok
+}
+
+func (r *ExtensionRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var rvs []string
+	for name := range r.ids {
+		rvs = append(rvs, name)
+	}
+	sort.Strings(rvs)
+	return rvs
+}
+
+func (r *ExtensionRegistry) lookup(id byte) (Extension, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	name, ok := r.names[id]
+	if !ok {
+		return Extension{}, false
+	}
+	return r.exts[name], true
+}
+
+func (r *ExtensionRegistry) each(f func(Extension) error) error {
+	r.mu.RLock()
+	var exts []Extension
+	for _, ext := range r.exts {
+		exts = append(exts, ext)
+	}
+	r.mu.RUnlock()
+	sort.Slice(exts, func(i, j int) bool { return exts[i].Name < exts[j].Name })
+	for _, ext := range exts {
+		if err := f(ext); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExtensionSession is the per-connection side of the extension protocol.
+type ExtensionSession struct {
+	Registry *ExtensionRegistry
+	Conn     *PeerConn
+
+	mu        sync.RWMutex
+	local     ExtendedHandshake
+	remote    ExtendedHandshake
+	gotRemote bool
+	remoteIDs map[string]byte
+	values    map[string]interface{}
+}
+
+func NewExtensionSession(registry *ExtensionRegistry, conn *PeerConn) *ExtensionSession {
+	return &ExtensionSession{
+		Registry:  registry,
+		Conn:      conn,
+		remoteIDs: make(map[string]byte),
+		values:    make(map[string]interface{}),
+	}
+}
+
+// SendHandshake fills in the m dictionary from the registry and sends h.
+func (s *ExtensionSession) SendHandshake(h ExtendedHandshake) error {
+	h.M = make(map[string]int64)
+	for _, name := range s.Registry.Names() {
+		id, _ := s.Registry.LocalID(name)
+		h.M[name] = int64(id)
+	}
+	s.mu.Lock()
+	s.local = h
+	s.mu.Unlock()
+	return s.Conn.WriteMessage(ExtendedMessage(ExtHandshakeID, h.Encode()))
+}
+
+func (s *ExtensionSession) Local() ExtendedHandshake {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.local
+}
+
+// Remote returns the peer's extended handshake, if one has arrived.
+func (s *ExtensionSession) Remote() (ExtendedHandshake, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.remote, s.gotRemote
+}
+
+func (s *ExtensionSession) Supports(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.remoteIDs[name]
+	return ok
+}
+
+// Send delivers payload to the peer under the id it assigned to name.
+func (s *ExtensionSession) Send(name string, payload []byte) error {
+	s.mu.RLock()
+	id, ok := s.remoteIDs[name]
+	s.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %v", ExtensionNotSupportedError, name)
+	}
+	return s.Conn.WriteMessage(ExtendedMessage(id, payload))
+}
+
+// per-session state for extensions
+func (s *ExtensionSession) Value(key string) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[key]
+}
+
+func (s *ExtensionSession) SetValue(key string, v interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = v
+}
+
+// HandleMessage dispatches an extended message. Other messages are left
+// for the caller and reported as not handled.
+func (s *ExtensionSession) HandleMessage(m Message) (bool, error) {
+	if m.KeepAlive || m.ID != MsgExtended {
+		return false, nil
+	}
+	if len(m.Payload) == 0 {
+		return true, fmt.Errorf("%w: empty extended message", MessageFormatError)
+	}
+	extID, payload := m.Payload[0], m.Payload[1:]
+	if extID == ExtHandshakeID {
+		return true, s.handleHandshake(payload)
+	}
+	ext, ok := s.Registry.lookup(extID)
+	if !ok || ext.Handle == nil {
+		// the peer used an id we never advertised; ignore it
+		return true, nil
+	}
+	return true, ext.Handle(s, payload)
+}
+
+func (s *ExtensionSession) handleHandshake(payload []byte) error {
+	h, err := ParseExtendedHandshake(payload)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	// a later handshake updates the earlier one, id 0 disables an extension
+	if s.gotRemote {
+		for k, v := range s.remote.M {
+			if _, ok := h.M[k]; !ok {
+				h.M[k] = v
+			}
+		}
+	}
+	s.remote = h
+	s.gotRemote = true
+	s.remoteIDs = make(map[string]byte)
+	for name, id := range h.M {
+		if id > 0 && id <= 255 {
+			s.remoteIDs[name] = byte(id)
+		}
+	}
+	s.mu.Unlock()
+
+	return s.Registry.each(func(ext Extension) error {
+		if ext.OnHandshake != nil && s.Supports(ext.Name) {
+			return ext.OnHandshake(s)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,93 @@
+package bencode
+
+import (
+	"net"
+	"testing"
+)
+
+func TestExtendedHandshakeCodec(t *testing.T) {
+	h := ExtendedHandshake{
+		M:            map[string]int64{"ut_metadata": 3, "ut_pex": 1},
+		V:            "gobencode 0.1",
+		YourIP:       net.ParseIP("10.0.0.7"),
+		Port:         6881,
+		Reqq:         250,
+		MetadataSize: 31235,
+	}
+	got, err := ParseExtendedHandshake(h.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.M["ut_metadata"] != 3 || got.M["ut_pex"] != 1 || got.V != h.V ||
+		!got.YourIP.Equal(h.YourIP) || got.Port != 6881 || got.Reqq != 250 || got.MetadataSize != 31235 {
+		t.Fatalf("round trip: %+v", got)
+	}
+	if _, err := ParseExtendedHandshake([]byte("le")); err != ExtensionHandshakeError {
+		t.Fatalf("expect ExtensionHandshakeError, got %v", err)
+	}
+}
+
+func TestExtensionDispatch(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	received := make(chan string, 1)
+	regA := NewExtensionRegistry()
+	regA.Register(Extension{Name: "lt_donthave"})
+	regA.Register(Extension{
+		Name: "x_echo",
+		Handle: func(s *ExtensionSession, payload []byte) error {
+			received <- string(payload)
+			return nil
+		},
+	})
+	if _, err := regA.Register(Extension{Name: "x_echo"}); err == nil {
+		t.Fatalf("duplicated name shall be rejected")
+	}
+
+	handshaked := make(chan struct{})
+	regB := NewExtensionRegistry()
+	regB.Register(Extension{
+		Name: "x_echo",
+		OnHandshake: func(s *ExtensionSession) error {
+			close(handshaked)
+			return nil
+		},
+	})
+
+	sa := NewExtensionSession(regA, NewPeerConn(a))
+	sb := NewExtensionSession(regB, NewPeerConn(b))
+
+	pump := func(s *ExtensionSession) {
+		for {
+			m, err := s.Conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			s.HandleMessage(m)
+		}
+	}
+	go pump(sa)
+	go pump(sb)
+
+	go sa.SendHandshake(ExtendedHandshake{V: "A"})
+	<-handshaked
+	if !sb.Supports("x_echo") || sb.Supports("ut_pex") {
+		t.Fatalf("negotiation failed")
+	}
+	if h, ok := sb.Remote(); !ok || h.V != "A" {
+		t.Fatalf("remote handshake: %+v", h)
+	}
+	if err := sb.Send("ut_pex", nil); err == nil {
+		t.Fatalf("send to unsupported extension shall fail")
+	}
+
+	// A advertised x_echo as id 2, B has to use it
+	if err := sb.Send("x_echo", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "hello" {
+		t.Fatalf("got %q", got)
+	}
+}
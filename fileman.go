@@ -1,89 +1,492 @@
 package bencode
 
 import (
-	"io/ioutil"
+	"bytes"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 )
 
-func walkPathSub(pwd string, a *[]string) {
-	files, err := ioutil.ReadDir(pwd)
+const (
+	// inner pieces hashed per candidate file
+	DefaultCheckPieces = 8
+)
+
+var (
+	NoMatchError        = errors.New("no matching file")
+	AmbiguousMatchError = errors.New("ambiguous file match")
+)
+
+// FileManOptions narrow down what a FileMan indexes. Globs use path.Match
+// syntax and are tried against the base name and the slash separated path
+// below the root.
+type FileManOptions struct {
+	Include []string // when set, only matching files are indexed
+	Exclude []string // matching files and directories are skipped
+
+	FollowSymlinks bool
+}
+
+// FileMan indexes the files under a set of roots by size and base name.
+// Lookups are safe for concurrent use, also while a rescan runs.
+type FileMan struct {
+	roots []string
+	opts  FileManOptions
+
+	// how many of the pieces inside a file confirm it; 0 hashes them all
+	CheckPieces int
+
+	mu     sync.RWMutex
+	sizes  map[string]int64
+	bySize map[int64][]string
+	byBase map[string][]string
+}
+
+// NewFileMan indexes the working directory, by its relative name when
+// the absolute one cannot be had.
+func NewFileMan() *FileMan {
+	now, err := os.Getwd()
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("fileman: %v, using .", err)
+		now = "."
+	}
+	return NewFileManAt(now)
+}
+
+// NewFileManAt indexes the files under root.
+func NewFileManAt(root string) *FileMan {
+	return NewFileManWith(FileManOptions{}, root)
+}
+
+func NewFileManWith(opts FileManOptions, roots ...string) *FileMan {
+	fm := &FileMan{
+		opts:        opts,
+		CheckPieces: DefaultCheckPieces,
+		sizes:       make(map[string]int64),
+		bySize:      make(map[int64][]string),
+		byBase:      make(map[string][]string),
 	}
-	nxd := []string{}
-	for _, fi := range files {
-		if fi.Name() == "." || fi.Name() == ".." {
-			log.Printf("=>>> %v", fi.Name())
+	for _, root := range roots {
+		fm.roots = append(fm.roots, filepath.ToSlash(filepath.Clean(root)))
+	}
+	fm.Rescan()
+	return fm
+}
+
+func (fm *FileMan) Roots() []string {
+	return append([]string(nil), fm.roots...)
+}
+
+// Len is the number of files indexed.
+func (fm *FileMan) Len() int {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+	return len(fm.sizes)
+}
+
+// Rescan brings the index up to date for the given paths, files or
+// directories, or for all the roots when none are given. Only what
+// changed under them is touched, and the result is the same as after a
+// full scan. Paths outside the roots are ignored.
+func (fm *FileMan) Rescan(paths ...string) {
+	if len(paths) == 0 {
+		paths = fm.roots
+	}
+	for _, p := range paths {
+		p = filepath.ToSlash(filepath.Clean(p))
+		root, ok := fm.rootOf(p)
+		if !ok {
+			log.Printf("fileman: %v is outside the roots, not rescanned", p)
 			continue
 		}
-		full := path.Join(pwd, fi.Name())
-		if fi.IsDir() {
-			nxd = append(nxd, full)
-			continue
+		found := make(map[string]int64)
+		if !fm.excludedAbove(root, p) {
+			fm.walk(p, true, found, make(map[string]bool))
 		}
-		*a = append(*a, full)
+
+		fm.mu.Lock()
+		for fi := range fm.sizes {
+			if _, ok := found[fi]; !ok && underPath(fi, p) {
+				fm.remove(fi)
+			}
+		}
+		for fi, size := range found {
+			if old, ok := fm.sizes[fi]; ok {
+				if old == size {
+					continue
+				}
+				fm.remove(fi)
+			}
+			fm.add(fi, size)
+		}
+		fm.mu.Unlock()
 	}
-	for _, nd := range nxd {
-		walkPath(nd, a)
+}
+
+func underPath(fi, p string) bool {
+	if p == "." {
+		return fi == "." || !(path.IsAbs(fi) || fi == ".." || strings.HasPrefix(fi, "../"))
 	}
+	return fi == p || strings.HasPrefix(fi, strings.TrimSuffix(p, "/")+"/")
 }
 
-func walkPath(pwd string, a *[]string) {
-	walkPathSub(pwd, a)
-	for i := range *a {
-		(*a)[i] = filepath.ToSlash((*a)[i])
+// rootOf returns the root p lies under.
+func (fm *FileMan) rootOf(p string) (string, bool) {
+	for _, root := range fm.roots {
+		if underPath(p, root) {
+			return root, true
+		}
 	}
+	return "", false
 }
 
-type FileMan struct {
-	filels []string
+func (fm *FileMan) isRoot(p string) bool {
+	for _, root := range fm.roots {
+		if p == root {
+			return true
+		}
+	}
+	return false
 }
 
-func NewFileMan() *FileMan {
-	rv := &FileMan{}
-	now, err := os.Getwd()
-	if err != nil {
-		log.Fatal(err)
+// excludedAbove tells whether a directory between root and p is
+// excluded, which hides p from a full scan.
+func (fm *FileMan) excludedAbove(root, p string) bool {
+	for d := path.Dir(p); p != root && d != root; d = path.Dir(d) {
+		if matchAny(fm.opts.Exclude, path.Base(d), fm.rel(d)) {
+			return true
+		}
 	}
-	walkPath(now, &rv.filels)
-	return rv
+	return false
 }
 
-func lookupNowWith(filels []string, that string, length int64, strict bool) (string, bool) {
-	that = filepath.ToSlash(that)
-	for _, fi := range filels {
-		stat, err := os.Stat(fi)
-		if err != nil {
-			log.Print(err)
-			continue
+// must hold fm.mu
+func (fm *FileMan) add(fi string, size int64) {
+	fm.sizes[fi] = size
+	fm.bySize[size] = append(fm.bySize[size], fi)
+	base := path.Base(fi)
+	fm.byBase[base] = append(fm.byBase[base], fi)
+}
+
+// must hold fm.mu
+func (fm *FileMan) remove(fi string) {
+	size := fm.sizes[fi]
+	delete(fm.sizes, fi)
+	fm.bySize[size] = dropString(fm.bySize[size], fi)
+	if len(fm.bySize[size]) == 0 {
+		delete(fm.bySize, size)
+	}
+	base := path.Base(fi)
+	fm.byBase[base] = dropString(fm.byBase[base], fi)
+	if len(fm.byBase[base]) == 0 {
+		delete(fm.byBase, base)
+	}
+}
+
+func dropString(ls []string, s string) []string {
+	for i, v := range ls {
+		if v == s {
+			return append(ls[:i:i], ls[i+1:]...)
+		}
+	}
+	return ls
+}
+
+// rel is the part of fi below the root holding it.
+func (fm *FileMan) rel(fi string) string {
+	for _, root := range fm.roots {
+		if underPath(fi, root) && fi != root {
+			if root == "." {
+				return fi
+			}
+			return strings.TrimPrefix(fi[len(root):], "/")
 		}
+	}
+	return path.Base(fi)
+}
 
-		if length != stat.Size() {
-			continue
+func matchAny(globs []string, base, rel string) bool {
+	for _, g := range globs {
+		if ok, _ := path.Match(g, base); ok {
+			return true
+		}
+		if ok, _ := path.Match(g, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// walk collects the files under p. Unreadable entries are logged and
+// skipped; visited holds the directories above p, against symlink loops.
+func (fm *FileMan) walk(p string, top bool, found map[string]int64, visited map[string]bool) {
+	lstat := os.Lstat
+	if top {
+		lstat = os.Stat
+	}
+	stat, err := lstat(p)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Print(err)
+		}
+		return
+	}
+	base, rel := path.Base(p), fm.rel(p)
+	if stat.Mode()&os.ModeSymlink != 0 {
+		if !fm.opts.FollowSymlinks {
+			return
+		}
+		if stat, err = os.Stat(p); err != nil {
+			log.Print(err)
+			return
 		}
+	}
+	// the filters apply to everything below the roots
+	filter := !top || !fm.isRoot(p)
+	if filter && matchAny(fm.opts.Exclude, base, rel) {
+		return
+	}
+	if !stat.IsDir() {
+		if !stat.Mode().IsRegular() {
+			return
+		}
+		if filter && len(fm.opts.Include) > 0 && !matchAny(fm.opts.Include, base, rel) {
+			return
+		}
+		found[p] = stat.Size()
+		return
+	}
 
+	real, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if visited[real] {
+		return
+	}
+	visited[real] = true
+	defer delete(visited, real)
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		// permission problems and the like only cost this directory
+		log.Print(err)
+		return
+	}
+	for _, e := range entries {
+		fm.walk(path.Join(p, e.Name()), false, found, visited)
+	}
+}
+
+// withSize lists the indexed files of the given size.
+func (fm *FileMan) withSize(length int64) []string {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+	return append([]string(nil), fm.bySize[length]...)
+}
+
+// WithName lists the indexed files with the given base name.
+func (fm *FileMan) WithName(base string) []string {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+	return append([]string(nil), fm.byBase[base]...)
+}
+
+// Lookup finds a file of the given length by its path suffix, or failing
+// that by its extension, as long as only one file fits.
+func (fm *FileMan) Lookup(name string, length int64) (string, bool) {
+	that := filepath.ToSlash(name)
+	var guesses []string
+	for _, fi := range fm.withSize(length) {
 		if strings.HasSuffix(fi, that) {
 			return fi, true
 		}
-
-		if !strict {
-			if path.Ext(fi) == path.Ext(that) {
-				return fi, true
-			}
+		if path.Ext(fi) == path.Ext(that) {
+			guesses = append(guesses, fi)
 		}
 	}
+	// a guess is only safe when nothing else fits
+	if len(guesses) == 1 {
+		return guesses[0], true
+	}
+	if len(guesses) > 1 {
+		log.Printf("%v: %v files of %v byte(s) with the same extension", that, len(guesses), length)
+	}
 	return "", false
 }
 
-func (fm *FileMan) Lookup(name string, length int64) (string, bool) {
-	filepath, ok := lookupNowWith(fm.filels, name, length, true)
-	if ok {
-		return filepath, ok
+// Candidate is a file on disk that may hold a torrent entry.
+type Candidate struct {
+	Path    string
+	Checked int // inner pieces hashed
+	Matched int // of those, the ones that passed
+
+	// 3 for a path suffix match, 2 for the same base name, 1 for the
+	// same extension
+	NameHint int
+}
+
+func (c Candidate) Confirmed() bool {
+	return c.Checked > 0 && c.Matched == c.Checked
+}
+
+type MatchResult struct {
+	Entry FileEntry
+
+	// best first; files whose pieces all failed are left out
+	Candidates []Candidate
+
+	// the best candidates tie and their content does not settle it
+	Ambiguous bool
+}
+
+// Best returns the winning candidate, if there is a clear one.
+func (r MatchResult) Best() (Candidate, bool) {
+	if len(r.Candidates) == 0 || r.Ambiguous {
+		return Candidate{}, false
+	}
+	return r.Candidates[0], true
+}
+
+// innerPieces returns the pieces lying entirely inside fe, as [first, last).
+func innerPieces(t *Torrent, fe FileEntry) (int, int) {
+	pl := t.PieceLength()
+	first := int((fe.Offset + pl - 1) / pl)
+	end := fe.Offset + fe.Length
+	last := int(end / pl)
+	if end == t.TotalLength() {
+		// the short last piece
+		last = t.PieceCount()
+	}
+	if last < first {
+		last = first
+	}
+	return first, last
+}
+
+// samplePieces spreads n picks over [first, last), both ends included.
+func samplePieces(first, last, n int) []int {
+	count := last - first
+	if n <= 0 || n >= count {
+		n = count
+	}
+	var rvs []int
+	for k := 0; k < n; k++ {
+		i := first
+		if n > 1 {
+			i = first + k*(count-1)/(n-1)
+		}
+		rvs = append(rvs, i)
+	}
+	return rvs
+}
+
+func entryName(t *Torrent, fe FileEntry) string {
+	if len(fe.Path) == 0 {
+		return t.Name()
+	}
+	return path.Join(fe.Path...)
+}
+
+func nameHint(fi, name string) int {
+	switch {
+	case fi == name || strings.HasSuffix(fi, "/"+name):
+		return 3
+	case path.Base(fi) == path.Base(name):
+		return 2
+	case path.Ext(fi) == path.Ext(name):
+		return 1
+	}
+	return 0
+}
+
+// checkCandidate hashes the given pieces of fe as found in the file fi.
+func checkCandidate(t *Torrent, fe FileEntry, fi string, pieces []int) Candidate {
+	c := Candidate{Path: fi, Checked: len(pieces)}
+	if len(pieces) == 0 {
+		return c
+	}
+	fin, err := os.Open(fi)
+	if err != nil {
+		log.Print(err)
+		return c
+	}
+	defer fin.Close()
+	for _, i := range pieces {
+		buf := make([]byte, t.PieceSize(i))
+		if _, err := fin.ReadAt(buf, int64(i)*t.PieceLength()-fe.Offset); err != nil {
+			continue
+		}
+		if bytes.Equal(calcSha1Hash(buf), t.PieceHash(i)) {
+			c.Matched++
+		}
+	}
+	return c
+}
+
+// Match ranks the files of the same size as fe by how many of the pieces
+// lying entirely inside it they reproduce, then by how close their names
+// are. Files too small to contain a whole piece can only be told apart by
+// name.
+func (fm *FileMan) Match(t *Torrent, fe FileEntry) MatchResult {
+	first, last := innerPieces(t, fe)
+	pieces := samplePieces(first, last, fm.CheckPieces)
+	name := entryName(t, fe)
+
+	rv := MatchResult{Entry: fe}
+	for _, fi := range fm.withSize(fe.Length) {
+		c := checkCandidate(t, fe, fi, pieces)
+		if c.Checked > 0 && c.Matched == 0 {
+			continue
+		}
+		c.NameHint = nameHint(fi, name)
+		rv.Candidates = append(rv.Candidates, c)
+	}
+	sort.SliceStable(rv.Candidates, func(i, j int) bool {
+		a, b := rv.Candidates[i], rv.Candidates[j]
+		if a.Matched != b.Matched {
+			return a.Matched > b.Matched
+		}
+		return a.NameHint > b.NameHint
+	})
+	if len(rv.Candidates) > 1 {
+		a, b := rv.Candidates[0], rv.Candidates[1]
+		// identical copies that both verify are as good as one
+		rv.Ambiguous = a.Matched == b.Matched && a.NameHint == b.NameHint && !a.Confirmed()
+	}
+	return rv
+}
+
+// LookupEntry finds the file holding entry index of t by its content.
+func (fm *FileMan) LookupEntry(t *Torrent, index int) (string, error) {
+	files := t.Files()
+	if index < 0 || index >= len(files) {
+		return "", fmt.Errorf("%w: file %v", OutOfRangeError, index)
+	}
+	r := fm.Match(t, files[index])
+	name := entryName(t, files[index])
+	best, ok := r.Best()
+	if !ok {
+		if len(r.Candidates) == 0 {
+			return "", fmt.Errorf("%w: %v", NoMatchError, name)
+		}
+		var tied []string
+		top := r.Candidates[0]
+		for _, c := range r.Candidates {
+			if c.Matched == top.Matched && c.NameHint == top.NameHint {
+				tied = append(tied, c.Path)
+			}
+		}
+		return "", fmt.Errorf("%w: %v: %v", AmbiguousMatchError, name, strings.Join(tied, ", "))
+	}
+	if best.Matched < best.Checked {
+		log.Printf("%v: %v/%v piece(s) match in %v", name, best.Matched, best.Checked, best.Path)
 	}
-	log.Printf("try strict=false")
-	return lookupNowWith(fm.filels, name, length, false)
+	return best.Path, nil
 }
@@ -0,0 +1,172 @@
+package bencode
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileManMatch(t *testing.T) {
+	files := []testFile{
+		{[]string{"video", "movie.mkv"}, patternData(50000, 1)},
+		{[]string{"small.nfo"}, patternData(100, 2)},
+		{[]string{"extra", "bonus.mkv"}, patternData(70000, 3)},
+	}
+	raw, _ := buildTestTorrent("pack", 16*1024, files)
+	tr, err := ParseTorrent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// renamed and reorganized, next to decoys of the same sizes
+	dir := t.TempDir()
+	put := func(name string, data []byte) string {
+		p := filepath.ToSlash(filepath.Join(dir, name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	real := put("renamed/feature.mkv", files[0].data)
+	put("decoy/video/movie.mkv", patternData(50000, 9))
+	put("a/small.nfo", files[1].data)
+	put("b/small.nfo", patternData(100, 8))
+	bonus := put("bonus.mkv", files[2].data)
+	copyOf := put("copy/bonus-copy.mkv", files[2].data)
+
+	fm := NewFileManAt(dir)
+	r := fm.Match(tr, tr.Files()[0])
+	if len(r.Candidates) != 1 || r.Candidates[0].Path != real || !r.Candidates[0].Confirmed() {
+		t.Fatalf("movie: %+v", r)
+	}
+	if p, err := fm.LookupEntry(tr, 0); err != nil || p != real {
+		t.Fatalf("lookup movie: %v %v", p, err)
+	}
+
+	// no whole piece inside, and the names tie
+	if _, err := fm.LookupEntry(tr, 1); !errors.Is(err, AmbiguousMatchError) {
+		t.Fatalf("expect AmbiguousMatchError, got %v", err)
+	}
+
+	// two verified copies are not ambiguous, the better name wins
+	r = fm.Match(tr, tr.Files()[2])
+	if r.Ambiguous || len(r.Candidates) != 2 || r.Candidates[0].Path != bonus || r.Candidates[1].Path != copyOf {
+		t.Fatalf("bonus: %+v", r)
+	}
+
+	if _, err := NewFileManAt(t.TempDir()).LookupEntry(tr, 0); !errors.Is(err, NoMatchError) {
+		t.Fatalf("expect NoMatchError, got %v", err)
+	}
+	if got := samplePieces(2, 12, 4); len(got) != 4 || got[0] != 2 || got[3] != 11 {
+		t.Fatalf("sample: %v", got)
+	}
+}
+
+func TestFileManIndex(t *testing.T) {
+	dir := t.TempDir()
+	put := func(name string, n int) string {
+		p := filepath.ToSlash(filepath.Join(dir, name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, patternData(n, 0), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	a := put("music/a.flac", 300)
+	put("music/cover.jpg", 20)
+	put("tmp/partial.part", 300)
+	put("music/.cache/x.flac", 300)
+	os.Symlink(filepath.Join(dir, "music"), filepath.Join(dir, "link"))
+
+	fm := NewFileManWith(FileManOptions{Exclude: []string{"*.part", ".cache"}}, dir)
+	if fm.Len() != 2 {
+		t.Fatalf("indexed %v file(s)", fm.Len())
+	}
+	if p, ok := fm.Lookup("music/a.flac", 300); !ok || p != a {
+		t.Fatalf("lookup: %v %v", p, ok)
+	}
+	if got := fm.WithName("cover.jpg"); len(got) != 1 {
+		t.Fatalf("by name: %v", got)
+	}
+
+	only := NewFileManWith(FileManOptions{Include: []string{"*.flac"}, FollowSymlinks: true}, dir)
+	if got := only.WithName("a.flac"); len(got) != 2 {
+		t.Fatalf("through the symlink: %v", got)
+	}
+	if only.Len() != 4 {
+		t.Fatalf("include: %v file(s)", only.Len())
+	}
+
+	// incremental changes
+	b := put("music/b.flac", 300)
+	os.Remove(a)
+	put("music/cover.jpg", 21)
+	fm.Rescan(filepath.Join(dir, "music"))
+	if got := fm.withSize(300); len(got) != 1 || got[0] != b {
+		t.Fatalf("after rescan: %v", got)
+	}
+	if len(fm.withSize(20)) != 0 || len(fm.withSize(21)) != 1 {
+		t.Fatal("size change missed")
+	}
+
+	// lookups while rescanning
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			fm.Rescan()
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		fm.Lookup("b.flac", 300)
+	}
+	<-done
+}
+
+func TestFileManRescanFilters(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"cache/a", "cache/sub/c", "b.tmp", "keep.bin"} {
+		p := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outside := filepath.Join(t.TempDir(), "o.bin")
+	os.WriteFile(outside, []byte("o"), 0644)
+
+	fm := NewFileManWith(FileManOptions{Exclude: []string{"cache", "*.tmp"}}, dir)
+	if fm.Len() != 1 {
+		t.Fatalf("full scan: %v file(s)", fm.Len())
+	}
+	for _, p := range []string{"cache", "b.tmp", "cache/sub", "cache/sub/c"} {
+		fm.Rescan(filepath.Join(dir, p))
+	}
+	fm.Rescan(outside)
+	if fm.Len() != 1 {
+		t.Fatalf("after rescans: %v file(s)", fm.Len())
+	}
+
+	// a root is indexed whatever its name
+	if fm := NewFileManWith(FileManOptions{Exclude: []string{"*.tmp"}}, filepath.Join(dir, "b.tmp")); fm.Len() != 1 {
+		t.Fatalf("root: %v file(s)", fm.Len())
+	}
+}
+
+func TestFileManUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions do not apply to root")
+	}
+	dir := t.TempDir()
+	locked := filepath.Join(dir, "locked")
+	os.MkdirAll(locked, 0755)
+	os.WriteFile(filepath.Join(locked, "f"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(dir, "g"), []byte("y"), 0644)
+	os.Chmod(locked, 0)
+	defer os.Chmod(locked, 0755)
+	if fm := NewFileManAt(dir); fm.Len() != 1 {
+		t.Fatalf("indexed %v file(s)", fm.Len())
+	}
+}
@@ -0,0 +1,391 @@
+package bencode
+
+// Lossless conversion between bencode and JSON. Text strings become JSON
+// strings; anything else is tagged:
+//
+//	{"$hex": "00ff"} or {"$base64": "AP8="}  a byte string that is not UTF-8
+//	{"$int": "007"}                          an integer not written canonically
+//	"$hex:00ff" / "$base64:AP8="             such a byte string as a dictionary key
+//	"$$foo"                                  the key "$foo" itself
+//
+// Integers are copied digit for digit, so they keep any precision, and
+// dictionary keys keep the order of the document: converting there and
+// back gives the very same bytes, info-hash included.
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+var JSONConvertError = errors.New("json conversion error")
+
+type JSONOptions struct {
+	// tag binary strings as base64 instead of hex
+	Base64 bool
+	// indent nested values by this; compact when empty
+	Indent string
+}
+
+// BencodeToJSON converts one bencoded document to JSON.
+func BencodeToJSON(raw []byte, opts JSONOptions) ([]byte, error) {
+	c := &jsonConverter{raw: raw, opts: opts}
+	if err := c.value(); err != nil {
+		return nil, err
+	}
+	if c.pos != len(raw) {
+		return nil, RemainsError
+	}
+	if opts.Indent == "" {
+		return c.buf.Bytes(), nil
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, c.buf.Bytes(), "", opts.Indent); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// JSONToBencode converts a document made by BencodeToJSON back.
+func JSONToBencode(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	c := &bencodeConverter{dec: dec}
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", JSONConvertError, err)
+	}
+	if err := c.value(tok); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("%w: data after the document", JSONConvertError)
+	}
+	return c.buf.Bytes(), nil
+}
+
+// MarshalJSON converts the canonical encoding of b. The zero BNode is
+// null; an invalid node deeper in the tree is an error.
+func (b BNode) MarshalJSON() (js []byte, err error) {
+	if b.Cat == BNodeInvalidType {
+		return []byte("null"), nil
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			js, err = nil, fmt.Errorf("%w: %v", JSONConvertError, e)
+		}
+	}()
+	return BencodeToJSON(Encode(b), JSONOptions{})
+}
+
+// UnmarshalJSON leaves b alone for null, as json.Unmarshaler asks.
+func (b *BNode) UnmarshalJSON(data []byte) error {
+	if string(bytes.TrimSpace(data)) == "null" {
+		return nil
+	}
+	raw, err := JSONToBencode(data)
+	if err != nil {
+		return err
+	}
+	node, _, err := TryScan(raw)
+	if err != nil {
+		return err
+	}
+	*b = node
+	return nil
+}
+
+// jsonConverter walks the bencoded bytes, writing JSON as it goes.
+type jsonConverter struct {
+	raw  []byte
+	pos  int
+	opts JSONOptions
+	buf  bytes.Buffer
+}
+
+func (c *jsonConverter) fail(what string) error {
+	return fmt.Errorf("%w: %v at offset %v", GeneralFormatError, what, c.pos)
+}
+
+func (c *jsonConverter) value() error {
+	if c.pos >= len(c.raw) {
+		return c.fail("unexpected end")
+	}
+	switch b := c.raw[c.pos]; {
+	case b == 'd':
+		c.pos++
+		c.buf.WriteByte('{')
+		for first := true; ; first = false {
+			if c.pos >= len(c.raw) {
+				return c.fail("unterminated dictionary")
+			}
+			if c.raw[c.pos] == 'e' {
+				c.pos++
+				break
+			}
+			if !first {
+				c.buf.WriteByte(',')
+			}
+			key, err := c.bytes()
+			if err != nil {
+				return err
+			}
+			c.buf.WriteString(jsonQuote(c.key(key)))
+			c.buf.WriteByte(':')
+			if err := c.value(); err != nil {
+				return err
+			}
+		}
+		c.buf.WriteByte('}')
+	case b == 'l':
+		c.pos++
+		c.buf.WriteByte('[')
+		for first := true; ; first = false {
+			if c.pos >= len(c.raw) {
+				return c.fail("unterminated list")
+			}
+			if c.raw[c.pos] == 'e' {
+				c.pos++
+				break
+			}
+			if !first {
+				c.buf.WriteByte(',')
+			}
+			if err := c.value(); err != nil {
+				return err
+			}
+		}
+		c.buf.WriteByte(']')
+	case b == 'i':
+		end := bytes.IndexByte(c.raw[c.pos:], 'e')
+		if end < 0 {
+			return c.fail("unterminated integer")
+		}
+		lit := string(c.raw[c.pos+1 : c.pos+end])
+		if !isIntLiteral(lit) {
+			return c.fail("bad integer")
+		}
+		c.pos += end + 1
+		if isCanonicalInt(lit) {
+			c.buf.WriteString(lit)
+		} else {
+			fmt.Fprintf(&c.buf, `{"$int":%v}`, jsonQuote(lit))
+		}
+	case isDigit(b):
+		s, err := c.bytes()
+		if err != nil {
+			return err
+		}
+		if utf8.Valid(s) {
+			c.buf.WriteString(jsonQuote(string(s)))
+		} else if c.opts.Base64 {
+			fmt.Fprintf(&c.buf, `{"$base64":"%v"}`, base64.StdEncoding.EncodeToString(s))
+		} else {
+			fmt.Fprintf(&c.buf, `{"$hex":"%v"}`, hex.EncodeToString(s))
+		}
+	default:
+		return c.fail(fmt.Sprintf("unexpected %q", b))
+	}
+	return nil
+}
+
+// bytes reads a length-prefixed string.
+func (c *jsonConverter) bytes() ([]byte, error) {
+	colon := bytes.IndexByte(c.raw[c.pos:], ':')
+	if colon <= 0 {
+		return nil, c.fail("bad string")
+	}
+	var n int
+	for _, d := range c.raw[c.pos : c.pos+colon] {
+		if !isDigit(d) || n > len(c.raw) {
+			return nil, c.fail("bad string length")
+		}
+		n = n*10 + int(d-'0')
+	}
+	start := c.pos + colon + 1
+	if n > len(c.raw)-start {
+		return nil, c.fail("string past the end")
+	}
+	c.pos = start + n
+	return c.raw[start:c.pos], nil
+}
+
+func (c *jsonConverter) key(k []byte) string {
+	switch {
+	case !utf8.Valid(k) && c.opts.Base64:
+		return "$base64:" + base64.StdEncoding.EncodeToString(k)
+	case !utf8.Valid(k):
+		return "$hex:" + hex.EncodeToString(k)
+	case len(k) > 0 && k[0] == '$':
+		return "$" + string(k)
+	}
+	return string(k)
+}
+
+func isIntLiteral(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isCanonicalInt(s string) bool {
+	if s == "0" {
+		return true
+	}
+	s = strings.TrimPrefix(s, "-")
+	return isIntLiteral(s) && s[0] != '0'
+}
+
+// bencodeConverter reads JSON tokens, writing bencode as it goes.
+type bencodeConverter struct {
+	dec *json.Decoder
+	buf bytes.Buffer
+}
+
+func (c *bencodeConverter) fail(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %v at offset %v", JSONConvertError, fmt.Sprintf(format, args...), c.dec.InputOffset())
+}
+
+func (c *bencodeConverter) token() (json.Token, error) {
+	tok, err := c.dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", JSONConvertError, err)
+	}
+	return tok, nil
+}
+
+func (c *bencodeConverter) value(tok json.Token) error {
+	switch v := tok.(type) {
+	case string:
+		encodeBytes(&c.buf, []byte(v))
+	case json.Number:
+		if !isCanonicalInt(v.String()) {
+			return c.fail("%v is not an integer", v)
+		}
+		c.buf.WriteString("i" + v.String() + "e")
+	case json.Delim:
+		if v == '[' {
+			c.buf.WriteByte('l')
+			for c.dec.More() {
+				el, err := c.token()
+				if err != nil {
+					return err
+				}
+				if err := c.value(el); err != nil {
+					return err
+				}
+			}
+			c.token() // ]
+			c.buf.WriteByte('e')
+			return nil
+		}
+		return c.object()
+	default:
+		return c.fail("%v has no bencode form", v)
+	}
+	return nil
+}
+
+// object converts a dictionary or a tagged value; the opening brace has
+// been read.
+func (c *bencodeConverter) object() error {
+	if !c.dec.More() {
+		c.token() // }
+		c.buf.WriteString("de")
+		return nil
+	}
+	tok, err := c.token()
+	if err != nil {
+		return err
+	}
+	key := tok.(string)
+	switch key {
+	case "$hex", "$base64", "$int":
+		tok, err := c.token()
+		if err != nil {
+			return err
+		}
+		s, ok := tok.(string)
+		if !ok {
+			return c.fail("%v wants a string", key)
+		}
+		if c.dec.More() {
+			return c.fail("%v wants a single value", key)
+		}
+		c.token() // }
+		switch key {
+		case "$int":
+			if !isIntLiteral(s) {
+				return c.fail("bad integer %q", s)
+			}
+			c.buf.WriteString("i" + s + "e")
+		default:
+			b, err := decodeTagged(key, s)
+			if err != nil {
+				return c.fail("%v", err)
+			}
+			encodeBytes(&c.buf, b)
+		}
+		return nil
+	}
+
+	c.buf.WriteByte('d')
+	for {
+		k, err := c.key(key)
+		if err != nil {
+			return err
+		}
+		encodeBytes(&c.buf, k)
+		val, err := c.token()
+		if err != nil {
+			return err
+		}
+		if err := c.value(val); err != nil {
+			return err
+		}
+		if !c.dec.More() {
+			break
+		}
+		if tok, err = c.token(); err != nil {
+			return err
+		}
+		key = tok.(string)
+	}
+	c.token() // }
+	c.buf.WriteByte('e')
+	return nil
+}
+
+func (c *bencodeConverter) key(k string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(k, "$$"):
+		return []byte(k[1:]), nil
+	case strings.HasPrefix(k, "$hex:"):
+		return decodeTagged("$hex", k[len("$hex:"):])
+	case strings.HasPrefix(k, "$base64:"):
+		return decodeTagged("$base64", k[len("$base64:"):])
+	case strings.HasPrefix(k, "$"):
+		return nil, c.fail("unknown tagged key %q", k)
+	}
+	return []byte(k), nil
+}
+
+func decodeTagged(tag, s string) ([]byte, error) {
+	if tag == "$hex" {
+		return hex.DecodeString(s)
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
@@ -0,0 +1,90 @@
+package bencode
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	raw, _ := buildTestTorrent("round", 16*1024, []testFile{
+		{[]string{"a.bin"}, patternData(40000, 1)},
+		{[]string{"b", "c.txt"}, patternData(1000, 2)},
+	})
+	tr, err := ParseTorrent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := [][]byte{
+		raw,
+		// keys out of order, a binary key, a key starting with '$', a tag
+		// look-alike, huge and non-canonical integers
+		[]byte("d1:zi9223372036854775808e1:ai-0e2:\xff\x00le4:$hexd4:$hex1:xe1:mi007ee"),
+		[]byte("le"),
+	}
+	for _, opts := range []JSONOptions{{}, {Base64: true, Indent: "  "}} {
+		for _, doc := range docs {
+			js, err := BencodeToJSON(doc, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !json.Valid(js) {
+				t.Fatalf("invalid json %s", js)
+			}
+			back, err := JSONToBencode(js)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, js)
+			}
+			if !bytes.Equal(back, doc) {
+				t.Fatalf("round trip:\n%q\n%q\n%s", doc, back, js)
+			}
+		}
+	}
+
+	js, _ := BencodeToJSON(raw, JSONOptions{})
+	back, _ := JSONToBencode(js)
+	tr2, err := ParseTorrent(back)
+	if err != nil || tr2.InfoHash() != tr.InfoHash() {
+		t.Fatalf("info-hash changed: %v", err)
+	}
+	if !strings.Contains(string(js), `"pieces":{"$hex":"`) {
+		t.Errorf("pieces not tagged: %.200s", js)
+	}
+
+	var doc struct {
+		Name string
+		Meta BNode
+	}
+	in := `{"Name":"x","Meta":{"$$k":[],"n":12345678901234567,"s":{"$hex":"ff00"}}}`
+	if err := json.Unmarshal([]byte(in), &doc); err != nil {
+		t.Fatal(err)
+	}
+	m := doc.Meta.AsMap()
+	if m["n"].AsInt() != 12345678901234567 || m["s"].AsBinary()[0] != 0xff || m["$k"].Cat != BNodeList {
+		t.Fatalf("unmarshal %+v", m)
+	}
+	out, _ := json.Marshal(doc)
+	if string(out) != in {
+		t.Errorf("marshal %s", out)
+	}
+
+	// the zero node is null, both ways
+	var empty struct{ M BNode }
+	if out, err := json.Marshal(empty); err != nil || string(out) != `{"M":null}` {
+		t.Fatalf("zero node: %s %v", out, err)
+	}
+	if err := json.Unmarshal([]byte(`{"M":null}`), &empty); err != nil || empty.M.Cat != BNodeInvalidType {
+		t.Fatalf("null: %v", err)
+	}
+	if _, err := json.Marshal(NewList(BNode{})); !errors.Is(err, JSONConvertError) {
+		t.Fatalf("invalid item: %v", err)
+	}
+
+	for _, bad := range []string{`1.5`, `true`, `{"$hex":"zz"}`, `{"$what":1}`, `[1] 2`} {
+		if _, err := JSONToBencode([]byte(bad)); !errors.Is(err, JSONConvertError) {
+			t.Errorf("%v: %v", bad, err)
+		}
+	}
+}
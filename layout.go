@@ -0,0 +1,281 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+type LinkMode int
+
+const (
+	LinkHard LinkMode = iota
+	LinkSymbolic
+	LinkReflink
+	LinkCopy
+)
+
+func (m LinkMode) String() string {
+	switch m {
+	case LinkHard:
+		return "hardlink"
+	case LinkSymbolic:
+		return "symlink"
+	case LinkReflink:
+		return "reflink"
+	case LinkCopy:
+		return "copy"
+	}
+	return fmt.Sprintf("LinkMode(%d)", int(m))
+}
+
+// what to put in place of entries with no source file
+type MissingMode int
+
+const (
+	MissingSkip MissingMode = iota
+	MissingZero
+	MissingSparse
+)
+
+var (
+	TargetExistsError       = errors.New("target exists")
+	ReflinkUnsupportedError = errors.New("reflink not supported")
+)
+
+type LayoutOptions struct {
+	Mode    LinkMode
+	Missing MissingMode
+
+	// copy when a link cannot be made, across devices say
+	FallbackCopy bool
+
+	// replace targets that exist but differ
+	Overwrite bool
+
+	// only plan
+	DryRun bool
+}
+
+// LayoutAction is one step of a layout plan. Op is the link mode, or one
+// of "zero", "sparse", "skip" and "exists", or "link" for a symlink entry
+// of the torrent, whose Source is then the link text. A dry run reports
+// targets in the way as "conflict" rather than failing.
+type LayoutAction struct {
+	Entry  FileEntry
+	Target string
+	Source string
+	Op     string
+}
+
+func (a LayoutAction) String() string {
+	if a.Source != "" {
+		return fmt.Sprintf("%-8v %v -> %v", a.Op, a.Source, a.Target)
+	}
+	return fmt.Sprintf("%-8v %v (%v byte(s))", a.Op, a.Target, a.Entry.Length)
+}
+
+type LayoutPlan []LayoutAction
+
+func (p LayoutPlan) Print(w io.Writer) {
+	for _, a := range p {
+		fmt.Fprintln(w, a)
+	}
+}
+
+// Materialize recreates the layout of t under dir out of the source files,
+// by entry index, as found by CrossSeed for example. Padding entries and,
+// depending on opts.Missing, entries without a source are filled with
+// zeros. The plan is returned in either case, complete on a dry run.
+func (t *Torrent) Materialize(dir string, sources map[int]string, opts LayoutOptions) (LayoutPlan, error) {
+	s := NewStorage(t, dir)
+	defer s.Close()
+	var plan LayoutPlan
+	for _, fe := range t.Files() {
+		target, err := s.FilePath(fe)
+		if err != nil {
+			return plan, err
+		}
+		a := LayoutAction{Entry: fe, Target: target, Source: sources[fe.Index]}
+		switch {
+		case fe.IsSymlink():
+			link, err := t.symlinkText(dir, fe, target)
+			if err != nil {
+				return plan, err
+			}
+			a.Source, a.Op = link, "link"
+		case fe.IsPadding() || a.Source == "":
+			a.Source = ""
+			switch {
+			case opts.Missing == MissingZero:
+				a.Op = "zero"
+			case opts.Missing == MissingSparse || fe.IsPadding():
+				a.Op = "sparse"
+			default:
+				a.Op = "skip"
+			}
+		default:
+			a.Op = opts.Mode.String()
+		}
+		if a.Op != "skip" {
+			same, err := sameTarget(a, opts)
+			switch {
+			case opts.DryRun && errors.Is(err, TargetExistsError):
+				a.Op = "conflict"
+			case err != nil:
+				return plan, err
+			case same:
+				a.Op = "exists"
+			}
+		}
+		plan = append(plan, a)
+		if opts.DryRun || a.Op == "skip" || a.Op == "exists" || a.Op == "conflict" {
+			continue
+		}
+		op, err := materialize(a, opts)
+		if err != nil {
+			return plan, fmt.Errorf("%v: %w", a.Target, err)
+		}
+		plan[len(plan)-1].Op = op
+		// links share the mode of their source, leave that alone
+		if fe.IsExecutable() && op != LinkHard.String() && op != LinkSymbolic.String() {
+			if err := os.Chmod(a.Target, 0755); err != nil {
+				return plan, err
+			}
+		}
+	}
+	return plan, nil
+}
+
+// symlinkText points the link for fe at its `symlink path`, relative to
+// the link and kept inside the torrent root.
+func (t *Torrent) symlinkText(dir string, fe FileEntry, target string) (string, error) {
+	ps, err := sanitizePath(fe.Index, fe.SymlinkPath, t.pathPolicy)
+	if err != nil {
+		return "", err
+	}
+	root := dir
+	if t.IsMultiFile() {
+		name, err := sanitizePath(-1, []string{t.Name()}, t.pathPolicy)
+		if err != nil {
+			return "", err
+		}
+		root = filepath.Join(dir, name[0])
+	}
+	return filepath.Rel(filepath.Dir(target), filepath.Join(append([]string{root}, ps...)...))
+}
+
+// sameTarget reports a target already in place. One that is in the way
+// is an error unless it may be overwritten.
+func sameTarget(a LayoutAction, opts LayoutOptions) (bool, error) {
+	stat, err := os.Lstat(a.Target)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if a.Op == "link" {
+		if link, err := os.Readlink(a.Target); err == nil && link == a.Source {
+			return true, nil
+		}
+	} else if a.Source != "" {
+		if src, err := os.Stat(a.Source); err == nil {
+			if dst, err := os.Stat(a.Target); err == nil && os.SameFile(src, dst) {
+				return true, nil
+			}
+		}
+		// a copy is never the same file, go by the size as for zeros
+		copied := opts.Mode == LinkCopy || opts.Mode == LinkReflink || opts.FallbackCopy
+		if copied && stat.Mode().IsRegular() && stat.Size() == a.Entry.Length {
+			return true, nil
+		}
+	} else if stat.Mode().IsRegular() && stat.Size() == a.Entry.Length {
+		return true, nil
+	}
+	if !opts.Overwrite {
+		return false, fmt.Errorf("%w: %v", TargetExistsError, a.Target)
+	}
+	return false, nil
+}
+
+// materialize carries out one action and returns what was done.
+func materialize(a LayoutAction, opts LayoutOptions) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(a.Target), 0755); err != nil {
+		return a.Op, err
+	}
+	if opts.Overwrite {
+		if err := os.Remove(a.Target); err != nil && !os.IsNotExist(err) {
+			return a.Op, err
+		}
+	}
+	if a.Op == "link" {
+		return a.Op, os.Symlink(a.Source, a.Target)
+	}
+	if a.Source == "" {
+		return a.Op, createFilled(a.Target, a.Entry.Length, a.Op == "sparse")
+	}
+
+	var err error
+	switch opts.Mode {
+	case LinkHard:
+		err = os.Link(a.Source, a.Target)
+	case LinkSymbolic:
+		var abs string
+		if abs, err = filepath.Abs(a.Source); err == nil {
+			err = os.Symlink(abs, a.Target)
+		}
+	case LinkReflink:
+		err = reflinkFile(a.Source, a.Target)
+	case LinkCopy:
+		return a.Op, copyFile(a.Source, a.Target)
+	}
+	if err != nil && opts.FallbackCopy {
+		os.Remove(a.Target)
+		return LinkCopy.String(), copyFile(a.Source, a.Target)
+	}
+	return a.Op, err
+}
+
+func createFilled(name string, length int64, sparse bool) error {
+	fout, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer fout.Close()
+	if sparse {
+		return fout.Truncate(length)
+	}
+	if _, err := io.CopyN(fout, zeroReader{}, length); err != nil {
+		return err
+	}
+	return fout.Close()
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+func copyFile(src, dst string) error {
+	fin, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer fin.Close()
+	fout, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer fout.Close()
+	if _, err := io.Copy(fout, fin); err != nil {
+		return err
+	}
+	return fout.Close()
+}
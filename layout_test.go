@@ -0,0 +1,148 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMaterialize(t *testing.T) {
+	files := []testFile{
+		{[]string{"a.bin"}, patternData(40000, 1)},
+		{[]string{".pad", "24000"}, make([]byte, 24000)},
+		{[]string{"sub", "b.bin"}, patternData(30000, 2)},
+		{[]string{"c.bin"}, patternData(5000, 3)},
+	}
+	raw, _ := buildTestTorrent("pack", 16*1024, files)
+	tr, err := ParseTorrent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib := t.TempDir()
+	srcA := filepath.Join(lib, "x.bin")
+	srcB := filepath.Join(lib, "y.bin")
+	os.WriteFile(srcA, files[0].data, 0644)
+	os.WriteFile(srcB, files[2].data, 0644)
+	sources := map[int]string{0: srcA, 2: srcB}
+
+	dir := t.TempDir()
+	plan, err := tr.Materialize(dir, sources, LayoutOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []string
+	for _, a := range plan {
+		ops = append(ops, a.Op)
+	}
+	if strings.Join(ops, " ") != "hardlink sparse hardlink skip" {
+		t.Fatalf("plan %v", ops)
+	}
+	var out bytes.Buffer
+	plan.Print(&out)
+	if !strings.Contains(out.String(), srcA+" -> "+filepath.Join(dir, "pack", "a.bin")) {
+		t.Fatalf("printed plan:\n%v", out.String())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatal("dry run touched the disk")
+	}
+
+	if _, err := tr.Materialize(dir, sources, LayoutOptions{Missing: MissingZero}); err != nil {
+		t.Fatal(err)
+	}
+	sa, _ := os.Stat(srcA)
+	ta, _ := os.Stat(filepath.Join(dir, "pack", "a.bin"))
+	if !os.SameFile(sa, ta) {
+		t.Fatal("a.bin is not a hard link")
+	}
+	s := NewStorage(tr, dir)
+	// all but the two pieces holding the zeroed c.bin
+	if have := s.Verify(); have.Count() != tr.PieceCount()-2 || have.Has(5) {
+		t.Fatalf("materialized layout: %v/%v piece(s) verify", have.Count(), tr.PieceCount())
+	}
+	s.Close()
+
+	// a second run finds everything in place
+	plan, err = tr.Materialize(dir, sources, LayoutOptions{Missing: MissingZero})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range plan {
+		if a.Op != "exists" {
+			t.Fatalf("rerun: %v", a)
+		}
+	}
+
+	// a different file in the way
+	other := t.TempDir()
+	os.MkdirAll(filepath.Join(other, "pack"), 0755)
+	os.WriteFile(filepath.Join(other, "pack", "a.bin"), []byte("junk"), 0644)
+	if _, err := tr.Materialize(other, sources, LayoutOptions{}); !errors.Is(err, TargetExistsError) {
+		t.Fatalf("expect TargetExistsError, got %v", err)
+	}
+	plan, err = tr.Materialize(other, sources, LayoutOptions{DryRun: true})
+	if err != nil || plan[0].Op != "conflict" || plan[2].Op != "hardlink" {
+		t.Fatalf("dry run in the way: %v %v", plan, err)
+	}
+	plan, err = tr.Materialize(other, sources, LayoutOptions{Mode: LinkSymbolic, Overwrite: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l, err := os.Readlink(filepath.Join(other, "pack", "sub", "b.bin")); err != nil || l != srcB {
+		t.Fatalf("symlink: %v %v", l, err)
+	}
+
+	// reflinks are rare on test file systems, copies stand in
+	third := t.TempDir()
+	plan, err = tr.Materialize(third, sources, LayoutOptions{Mode: LinkReflink, FallbackCopy: true, Missing: MissingSparse})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op := plan[0].Op; op != "reflink" && op != "copy" {
+		t.Fatalf("reflink: %v", op)
+	}
+	if b, _ := os.ReadFile(filepath.Join(third, "pack", "sub", "b.bin")); !bytes.Equal(b, files[2].data) {
+		t.Fatal("copied content differs")
+	}
+	if st, err := os.Stat(filepath.Join(third, "pack", "c.bin")); err != nil || st.Size() != 5000 {
+		t.Fatalf("sparse file: %v", err)
+	}
+}
+
+func TestMaterializeCopyTwice(t *testing.T) {
+	files := []testFile{
+		{[]string{"a.bin"}, patternData(20000, 1)},
+		{[]string{"b.bin"}, patternData(10000, 2)},
+	}
+	raw, _ := buildTestTorrent("twice", 16*1024, files)
+	tr, err := ParseTorrent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib := t.TempDir()
+	sources := make(map[int]string)
+	for i, f := range files {
+		sources[i] = filepath.Join(lib, f.path[0])
+		os.WriteFile(sources[i], f.data, 0644)
+	}
+
+	dir := t.TempDir()
+	opts := LayoutOptions{Mode: LinkCopy}
+	if _, err := tr.Materialize(dir, sources, opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, dry := range []bool{true, false} {
+		opts.DryRun = dry
+		plan, err := tr.Materialize(dir, sources, opts)
+		if err != nil {
+			t.Fatalf("dry run %v: %v", dry, err)
+		}
+		for _, a := range plan {
+			if a.Op != "exists" {
+				t.Fatalf("dry run %v: %v", dry, a)
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package bencode
+
+// Magnet URI format
+// http://bittorrent.org/beps/bep_0009.html#magnet-uri-format
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var (
+	MagnetError = errors.New("invalid magnet link")
+)
+
+type Magnet struct {
+	InfoHash    [20]byte
+	DisplayName string
+	Trackers    []string
+	Peers       []string // x.pe
+	WebSeeds    []string // ws
+}
+
+func ParseMagnet(uri string) (Magnet, error) {
+	var m Magnet
+	u, err := url.Parse(uri)
+	if err != nil {
+		return m, fmt.Errorf("%w: %v", MagnetError, err)
+	}
+	if u.Scheme != "magnet" {
+		return m, fmt.Errorf("%w: scheme %q", MagnetError, u.Scheme)
+	}
+	q, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return m, fmt.Errorf("%w: %v", MagnetError, err)
+	}
+
+	found := false
+	for _, xt := range q["xt"] {
+		if !strings.HasPrefix(xt, "urn:btih:") {
+			continue
+		}
+		ih := strings.TrimPrefix(xt, "urn:btih:")
+		var raw []byte
+		switch len(ih) {
+		case 40:
+			raw, err = hex.DecodeString(ih)
+		case 32:
+			raw, err = base32.StdEncoding.DecodeString(strings.ToUpper(ih))
+		default:
+			err = fmt.Errorf("info-hash of %v char(s)", len(ih))
+		}
+		if err != nil {
+			return m, fmt.Errorf("%w: %v", MagnetError, err)
+		}
+		copy(m.InfoHash[:], raw)
+		found = true
+		break
+	}
+	if !found {
+		return m, fmt.Errorf("%w: no urn:btih", MagnetError)
+	}
+	m.DisplayName = q.Get("dn")
+	m.Trackers = q["tr"]
+	m.Peers = q["x.pe"]
+	m.WebSeeds = q["ws"]
+	return m, nil
+}
+
+func (m Magnet) String() string {
+	var sb strings.Builder
+	sb.WriteString("magnet:?xt=urn:btih:")
+	sb.WriteString(hex.EncodeToString(m.InfoHash[:]))
+	if m.DisplayName != "" {
+		sb.WriteString("&dn=" + url.QueryEscape(m.DisplayName))
+	}
+	for _, tr := range m.Trackers {
+		sb.WriteString("&tr=" + url.QueryEscape(tr))
+	}
+	for _, ws := range m.WebSeeds {
+		sb.WriteString("&ws=" + url.QueryEscape(ws))
+	}
+	for _, pe := range m.Peers {
+		sb.WriteString("&x.pe=" + url.QueryEscape(pe))
+	}
+	return sb.String()
+}
+
+// Magnet builds the magnet link for a loaded torrent.
+func (t *Torrent) Magnet() Magnet {
+	m := Magnet{
+		InfoHash:    t.InfoHash(),
+		DisplayName: t.Name(),
+		Trackers:    t.Announces(),
+	}
+	return m
+}
@@ -0,0 +1,408 @@
+package bencode
+
+// Extension for peers to send metadata files
+// http://bittorrent.org/beps/bep_0009.html
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	UtMetadata = "ut_metadata"
+
+	MetadataPieceSize = 16 * 1024
+
+	// refuse to assemble anything bigger than this
+	MaxMetadataSize = 32 << 20
+
+	MetadataRequest = 0
+	MetadataData    = 1
+	MetadataReject  = 2
+)
+
+var (
+	MetadataSizeError     = errors.New("invalid metadata size")
+	MetadataPieceError    = errors.New("invalid metadata piece")
+	MetadataHashError     = errors.New("metadata does not match info-hash")
+	MetadataRejectedError = errors.New("metadata request rejected")
+	NoPeerError           = errors.New("no peer could provide the data")
+)
+
+// key of the assembler in an ExtensionSession
+const metadataAssemblerKey = UtMetadata + ".assembler"
+
+type MetadataMessage struct {
+	Type      int64
+	Piece     int64
+	TotalSize int64
+	Data      []byte
+}
+
+func (m MetadataMessage) Encode() []byte {
+	d := map[string]BNode{
+		"msg_type": NewInt(m.Type),
+		"piece":    NewInt(m.Piece),
+	}
+	if m.Type == MetadataData {
+		d["total_size"] = NewInt(m.TotalSize)
+	}
+	return append(Encode(BNode{Map: d, Cat: BNodeMap}), m.Data...)
+}
+
+// ParseMetadataMessage decodes the dictionary and the data appended to it.
+func ParseMetadataMessage(payload []byte) (MetadataMessage, error) {
+	var m MetadataMessage
+	node, remains, err := TryScan(payload)
+	if err != nil {
+		return m, err
+	}
+	if node.Cat != BNodeMap {
+		return m, fmt.Errorf("%w: ut_metadata message is not a dictionary", MessageFormatError)
+	}
+	msgType, ok1 := node.Map["msg_type"]
+	piece, ok2 := node.Map["piece"]
+	if !ok1 || !ok2 || msgType.Cat != BNodeInteger || piece.Cat != BNodeInteger {
+		return m, fmt.Errorf("%w: ut_metadata message without msg_type/piece", MessageFormatError)
+	}
+	m.Type = *msgType.Int
+	m.Piece = *piece.Int
+	if ts, ok := node.Map["total_size"]; ok && ts.Cat == BNodeInteger {
+		m.TotalSize = *ts.Int
+	}
+	m.Data = remains
+	return m, nil
+}
+
+// MetadataAssembler collects metadata pieces and checks the result
+// against the info-hash.
+type MetadataAssembler struct {
+	InfoHash [20]byte
+
+	mu     sync.Mutex
+	size   int64
+	pieces [][]byte
+	have   int
+	result []byte
+	err    error
+	done   chan struct{}
+}
+
+func NewMetadataAssembler(infoHash [20]byte) *MetadataAssembler {
+	return &MetadataAssembler{
+		InfoHash: infoHash,
+		done:     make(chan struct{}),
+	}
+}
+
+func (a *MetadataAssembler) SetSize(size int64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if size <= 0 || size > MaxMetadataSize {
+		return fmt.Errorf("%w: %v", MetadataSizeError, size)
+	}
+	if a.size == size {
+		return nil
+	}
+	if a.size != 0 {
+		return fmt.Errorf("%w: %v, announced %v before", MetadataSizeError, size, a.size)
+	}
+	a.size = size
+	a.pieces = make([][]byte, (size+MetadataPieceSize-1)/MetadataPieceSize)
+	return nil
+}
+
+func (a *MetadataAssembler) pieceLength(piece int) int {
+	if piece == len(a.pieces)-1 {
+		return int(a.size - int64(piece)*MetadataPieceSize)
+	}
+	return MetadataPieceSize
+}
+
+func (a *MetadataAssembler) Missing() []int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var rvs []int
+	for i, p := range a.pieces {
+		if p == nil {
+			rvs = append(rvs, i)
+		}
+	}
+	return rvs
+}
+
+// Put stores one piece. Once the last one arrives the whole is verified;
+// on a mismatch every piece is dropped so they can be fetched again.
+func (a *MetadataAssembler) Put(piece int, data []byte) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.result != nil {
+		return true, nil
+	}
+	if piece < 0 || piece >= len(a.pieces) || len(data) != a.pieceLength(piece) {
+		return false, fmt.Errorf("%w: piece %v with %v byte(s)", MetadataPieceError, piece, len(data))
+	}
+	if a.pieces[piece] == nil {
+		a.pieces[piece] = append([]byte(nil), data...)
+		a.have++
+	}
+	if a.have < len(a.pieces) {
+		return false, nil
+	}
+	whole := bytes.Join(a.pieces, nil)
+	if sha1.Sum(whole) != a.InfoHash {
+		a.pieces = make([][]byte, len(a.pieces))
+		a.have = 0
+		return false, MetadataHashError
+	}
+	a.result = whole
+	close(a.done)
+	return true, nil
+}
+
+func (a *MetadataAssembler) fail(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.result == nil && a.err == nil {
+		a.err = err
+		close(a.done)
+	}
+}
+
+// Done is closed once the metadata is complete or the peer gave up.
+func (a *MetadataAssembler) Done() <-chan struct{} {
+	return a.done
+}
+
+func (a *MetadataAssembler) Result() ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.result, a.err
+}
+
+// NewMetadataExtension serves the info dictionary of t to peers; t may be
+// nil when we have nothing to serve. Pieces sent to us are handed to the
+// MetadataAssembler attached to the session with AttachMetadataAssembler.
+func NewMetadataExtension(t *Torrent) Extension {
+	return Extension{
+		Name: UtMetadata,
+		Handle: func(s *ExtensionSession, payload []byte) error {
+			msg, err := ParseMetadataMessage(payload)
+			if err != nil {
+				return err
+			}
+			switch msg.Type {
+			case MetadataRequest:
+				return serveMetadataPiece(s, t, msg.Piece)
+			case MetadataData:
+				if a, ok := s.Value(metadataAssemblerKey).(*MetadataAssembler); ok {
+					if _, err := a.Put(int(msg.Piece), msg.Data); err != nil {
+						if errors.Is(err, MetadataHashError) {
+							a.fail(err)
+						}
+						return err
+					}
+				}
+			case MetadataReject:
+				if a, ok := s.Value(metadataAssemblerKey).(*MetadataAssembler); ok {
+					a.fail(fmt.Errorf("%w: piece %v", MetadataRejectedError, msg.Piece))
+				}
+			}
+			return nil
+		},
+		OnHandshake: func(s *ExtensionSession) error {
+			a, ok := s.Value(metadataAssemblerKey).(*MetadataAssembler)
+			if !ok {
+				return nil
+			}
+			remote, _ := s.Remote()
+			if err := a.SetSize(remote.MetadataSize); err != nil {
+				a.fail(err)
+				return err
+			}
+			for _, piece := range a.Missing() {
+				req := MetadataMessage{Type: MetadataRequest, Piece: int64(piece)}
+				if err := s.Send(UtMetadata, req.Encode()); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func AttachMetadataAssembler(s *ExtensionSession, a *MetadataAssembler) {
+	s.SetValue(metadataAssemblerKey, a)
+}
+
+func serveMetadataPiece(s *ExtensionSession, t *Torrent, piece int64) error {
+	reject := MetadataMessage{Type: MetadataReject, Piece: piece}
+	if t == nil {
+		return s.Send(UtMetadata, reject.Encode())
+	}
+	raw := t.RawInfo()
+	begin := piece * MetadataPieceSize
+	if piece < 0 || begin >= int64(len(raw)) {
+		return s.Send(UtMetadata, reject.Encode())
+	}
+	end := begin + MetadataPieceSize
+	if end > int64(len(raw)) {
+		end = int64(len(raw))
+	}
+	data := MetadataMessage{
+		Type:      MetadataData,
+		Piece:     piece,
+		TotalSize: int64(len(raw)),
+		Data:      raw[begin:end],
+	}
+	return s.Send(UtMetadata, data.Encode())
+}
+
+// MetadataFetcher downloads the info dictionary for an info-hash.
+type MetadataFetcher struct {
+	InfoHash [20]byte
+	PeerID   [20]byte
+
+	// per peer, covering the whole exchange
+	Timeout time.Duration
+}
+
+func NewMetadataFetcher(infoHash [20]byte) *MetadataFetcher {
+	return &MetadataFetcher{
+		InfoHash: infoHash,
+		PeerID:   GeneratePeerID(),
+		Timeout:  30 * time.Second,
+	}
+}
+
+// FetchFrom asks one peer for the verified info dictionary.
+func (f *MetadataFetcher) FetchFrom(addr string) ([]byte, error) {
+	h := Handshake{InfoHash: f.InfoHash, PeerID: f.PeerID}
+	h.SetExtensionProtocol()
+	pc, remote, err := DialPeer(addr, h, f.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+	if !remote.SupportsExtensionProtocol() {
+		return nil, fmt.Errorf("%w: %v", ExtensionNotSupportedError, addr)
+	}
+	pc.ReadTimeout = 0
+	pc.Conn.SetDeadline(time.Now().Add(f.Timeout))
+
+	registry := NewExtensionRegistry()
+	registry.Register(NewMetadataExtension(nil))
+	session := NewExtensionSession(registry, pc)
+	a := NewMetadataAssembler(f.InfoHash)
+	AttachMetadataAssembler(session, a)
+	if err := session.SendHandshake(ExtendedHandshake{V: ClientVersion}); err != nil {
+		return nil, err
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			m, err := pc.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			if _, err := session.HandleMessage(m); err != nil {
+				log.Printf("ut_metadata from %v: %v", addr, err)
+			}
+			if remote, ok := session.Remote(); ok && !session.Supports(UtMetadata) {
+				readErr <- fmt.Errorf("%w: %v (%v)", ExtensionNotSupportedError, UtMetadata, remote.V)
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-a.Done():
+		return a.Result()
+	case err := <-readErr:
+		return nil, err
+	}
+}
+
+// Fetch tries the peers in turn until one of them delivers.
+func (f *MetadataFetcher) Fetch(addrs []string) (*Torrent, error) {
+	for _, addr := range addrs {
+		raw, err := f.FetchFrom(addr)
+		if err != nil {
+			log.Printf("metadata from %v: %v", addr, err)
+			continue
+		}
+		return NewTorrentFromInfoBytes(raw)
+	}
+	return nil, NoPeerError
+}
+
+// FetchMagnet resolves a magnet link; x.pe peers are tried after addrs.
+// The trackers of the link become the announce keys of the torrent.
+func (f *MetadataFetcher) FetchMagnet(m Magnet, addrs []string) (*Torrent, error) {
+	f.InfoHash = m.InfoHash
+	t, err := f.Fetch(append(append([]string(nil), addrs...), m.Peers...))
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Trackers) > 0 {
+		meta := t.Meta()
+		meta["announce"] = NewString(m.Trackers[0])
+		var tiers []BNode
+		for _, tr := range m.Trackers {
+			tiers = append(tiers, BNode{List: []BNode{NewString(tr)}, Cat: BNodeList})
+		}
+		meta["announce-list"] = BNode{List: tiers, Cat: BNodeList}
+	}
+	return t, nil
+}
+
+// ServeMetadata answers ut_metadata requests on one inbound connection
+// until the peer hangs up.
+func ServeMetadata(conn net.Conn, t *Torrent) error {
+	defer conn.Close()
+	pc := NewPeerConn(conn)
+	remote, err := pc.ReadHandshake()
+	if err != nil {
+		return err
+	}
+	if remote.InfoHash != t.InfoHash() {
+		return InfoHashError
+	}
+	h := Handshake{InfoHash: remote.InfoHash, PeerID: GeneratePeerID()}
+	h.SetExtensionProtocol()
+	if err := pc.WriteHandshake(h); err != nil {
+		return err
+	}
+
+	registry := NewExtensionRegistry()
+	registry.Register(NewMetadataExtension(t))
+	session := NewExtensionSession(registry, pc)
+	err = session.SendHandshake(ExtendedHandshake{
+		V:            ClientVersion,
+		MetadataSize: int64(len(t.RawInfo())),
+	})
+	if err != nil {
+		return err
+	}
+	for {
+		m, err := pc.ReadMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := session.HandleMessage(m); err != nil {
+			return err
+		}
+	}
+}
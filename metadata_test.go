@@ -0,0 +1,84 @@
+package bencode
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFetchMetadataOverLoopback(t *testing.T) {
+	// 1200 pieces make the info dictionary span two metadata pieces
+	raw, _ := buildTestTorrent("big", 16, []testFile{
+		{[]string{"data.bin"}, patternData(1200*16, 3)},
+	})
+	seed, err := ParseTorrent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seed.RawInfo()) <= MetadataPieceSize {
+		t.Fatalf("info dictionary too small for the test: %v", len(seed.RawInfo()))
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go ServeMetadata(conn, seed)
+		}
+	}()
+
+	m := seed.Magnet()
+	m.Peers = []string{ln.Addr().String()}
+	f := NewMetadataFetcher(m.InfoHash)
+	f.Timeout = 5 * time.Second
+	got, err := f.FetchMagnet(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.InfoHash() != seed.InfoHash() {
+		t.Fatalf("info-hash mismatch")
+	}
+	if !bytes.Equal(got.RawInfo(), seed.RawInfo()) {
+		t.Fatalf("info dictionary differs")
+	}
+	reparsed, err := ParseTorrent(got.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reparsed.InfoHash() != seed.InfoHash() || reparsed.Announces()[0] != "http://tracker.example/announce" {
+		t.Fatalf("rebuilt .torrent: %v", reparsed.Announces())
+	}
+
+	// nobody has this one
+	other := NewMetadataFetcher([20]byte{1, 2, 3})
+	other.Timeout = 2 * time.Second
+	if _, err := other.Fetch([]string{ln.Addr().String()}); err != NoPeerError {
+		t.Fatalf("expect NoPeerError, got %v", err)
+	}
+}
+
+func TestMetadataAssembler(t *testing.T) {
+	data := patternData(MetadataPieceSize+10, 9)
+	a := NewMetadataAssembler([20]byte{})
+	if err := a.SetSize(int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Put(1, data[:5]); err == nil {
+		t.Fatalf("short piece shall be refused")
+	}
+	a.Put(0, data[:MetadataPieceSize])
+	if _, err := a.Put(1, data[MetadataPieceSize:]); err != MetadataHashError {
+		t.Fatalf("expect MetadataHashError, got %v", err)
+	}
+	if len(a.Missing()) != 2 {
+		t.Fatalf("pieces shall be dropped after a hash failure")
+	}
+}
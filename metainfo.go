@@ -0,0 +1,342 @@
+package bencode
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+var (
+	MetainfoError = errors.New("invalid metainfo")
+)
+
+// FileEntry is one file of the torrent, in the order of the info dictionary.
+type FileEntry struct {
+	Index int
+
+	// path components without the torrent name, decoded for display and
+	// for the disk; RawPath keeps the bytes stored in the torrent
+	Path    []string
+	RawPath [][]byte
+	Length  int64
+
+	// offset of the first byte within the concatenated torrent data
+	Offset int64
+
+	// BEP 47; SymlinkPath is relative to the torrent root
+	Attr        FileAttr
+	SymlinkPath []string
+	SHA1        []byte
+}
+
+// ParseTorrent decodes a whole .torrent file. The info dictionary is kept
+// as raw bytes as well, so the info-hash does not depend on re-encoding.
+// An info dictionary missing the keys the accessors need is a MetainfoError.
+func ParseTorrent(raw []byte) (*Torrent, error) {
+	node, remains, err := TryScan(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(remains) > 0 {
+		return nil, RemainsError
+	}
+	if node.Cat != BNodeMap {
+		return nil, fmt.Errorf("%w: not a dictionary", MetainfoError)
+	}
+	infoNode, ok := node.Map["info"]
+	if !ok || infoNode.Cat != BNodeMap {
+		return nil, fmt.Errorf("%w: no info dictionary", MetainfoError)
+	}
+	if err := validateInfo(infoNode.Map); err != nil {
+		return nil, err
+	}
+	rawInfo, err := rawDictValue(raw, "info")
+	if err != nil {
+		return nil, err
+	}
+	meta := make(map[string]BNode)
+	for k, v := range node.Map {
+		if k != "info" {
+			meta[k] = v
+		}
+	}
+	return &Torrent{
+		info:    infoNode.Map,
+		rawInfo: rawInfo,
+		meta:    meta,
+	}, nil
+}
+
+func LoadTorrent(filename string) (*Torrent, error) {
+	chunk, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTorrent(chunk)
+}
+
+// NewTorrentFromInfoBytes builds a torrent from a bare info dictionary,
+// as received through ut_metadata.
+func NewTorrentFromInfoBytes(rawInfo []byte) (*Torrent, error) {
+	node, remains, err := TryScan(rawInfo)
+	if err != nil {
+		return nil, err
+	}
+	if len(remains) > 0 {
+		return nil, RemainsError
+	}
+	if node.Cat != BNodeMap {
+		return nil, fmt.Errorf("%w: info is not a dictionary", MetainfoError)
+	}
+	if err := validateInfo(node.Map); err != nil {
+		return nil, err
+	}
+	return &Torrent{
+		info:    node.Map,
+		rawInfo: append([]byte(nil), rawInfo...),
+		meta:    make(map[string]BNode),
+	}, nil
+}
+
+// validateInfo checks the keys the accessors rely on, so that a torrent
+// from an untrusted source cannot make them panic.
+func validateInfo(info map[string]BNode) error {
+	bad := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %v", MetainfoError, fmt.Sprintf(format, args...))
+	}
+	if n, ok := info["piece length"]; !ok || n.Cat != BNodeInteger || *n.Int <= 0 {
+		return bad("piece length is not a positive integer")
+	}
+	if n, ok := info["pieces"]; !ok || !n.IsBytes() || len(*n.Str)%20 != 0 {
+		return bad("pieces is not a string of 20-byte hashes")
+	}
+	files, multi := info["files"]
+	if !multi {
+		n, ok := info["length"]
+		if !ok {
+			return bad("no length and no files")
+		}
+		if n.Cat != BNodeInteger || *n.Int < 0 {
+			return bad("length is not an integer >= 0")
+		}
+		return nil
+	}
+	if files.Cat != BNodeList || len(files.List) == 0 {
+		return bad("files is not a non-empty list")
+	}
+	for i, f := range files.List {
+		if f.Cat != BNodeMap {
+			return bad("files[%v] is not a dictionary", i)
+		}
+		if n, ok := f.Map["length"]; !ok || n.Cat != BNodeInteger || *n.Int < 0 {
+			return bad("files[%v].length is not an integer >= 0", i)
+		}
+		p, ok := f.Map["path"]
+		if !ok || p.Cat != BNodeList {
+			return bad("files[%v].path is not a list", i)
+		}
+		for j, c := range p.List {
+			if !c.IsBytes() {
+				return bad("files[%v].path[%v] is not a string", i, j)
+			}
+		}
+	}
+	return nil
+}
+
+// rawDictValue returns the encoded bytes of the value under key in the
+// top-level dictionary of raw, exactly as they appear in the document.
+func rawDictValue(raw []byte, key string) (value []byte, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%w: %v", GeneralFormatError, e)
+		}
+	}()
+	if len(raw) == 0 || raw[0] != 'd' {
+		return nil, fmt.Errorf("%w: not a dictionary", MetainfoError)
+	}
+	rest := raw[1:]
+	for rest[0] != 'e' {
+		k, afterKey := scanString(rest)
+		_, afterValue := Scan(afterKey)
+		if k == key {
+			return afterKey[:len(afterKey)-len(afterValue)], nil
+		}
+		rest = afterValue
+	}
+	return nil, fmt.Errorf("%w: no %v key", MetainfoError, key)
+}
+
+// RawInfo returns the bencoded info dictionary.
+func (t *Torrent) RawInfo() []byte {
+	if t.rawInfo == nil {
+		t.rawInfo = Encode(BNode{Map: t.info, Cat: BNodeMap})
+	}
+	return t.rawInfo
+}
+
+func (t *Torrent) InfoHash() [20]byte {
+	return sha1.Sum(t.RawInfo())
+}
+
+func (t *Torrent) InfoHashHex() string {
+	h := t.InfoHash()
+	return hex.EncodeToString(h[:])
+}
+
+func (t *Torrent) Info() map[string]BNode {
+	return t.info
+}
+
+// Meta returns the top-level keys besides info (announce, comment, ...).
+func (t *Torrent) Meta() map[string]BNode {
+	if t.meta == nil {
+		t.meta = make(map[string]BNode)
+	}
+	return t.meta
+}
+
+// Bytes encodes the whole .torrent file, with the info dictionary copied
+// verbatim from RawInfo.
+func (t *Torrent) Bytes() []byte {
+	return encodeDictWithRaw(t.Meta(), map[string][]byte{"info": t.RawInfo()})
+}
+
+// encodeDictWithRaw encodes m as a dictionary, splicing the pre-encoded
+// values of raws under their keys.
+func encodeDictWithRaw(m map[string]BNode, raws map[string][]byte) []byte {
+	keys := make([]string, 0, len(m)+len(raws))
+	for k := range m {
+		if _, ok := raws[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	for k := range raws {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	buf.WriteByte('d')
+	for _, k := range keys {
+		encodeBytes(&buf, []byte(k))
+		if r, ok := raws[k]; ok {
+			buf.Write(r)
+		} else {
+			encodeNode(&buf, m[k])
+		}
+	}
+	buf.WriteByte('e')
+	return buf.Bytes()
+}
+
+// Name prefers name.utf-8, then decodes name by the declared encoding.
+func (t *Torrent) Name() string {
+	if s, ok := utf8Alternate(t.info, "name"); ok {
+		return s
+	}
+	if n, ok := t.info["name"]; ok && n.IsBytes() {
+		return t.decodeName(n.AsString())
+	}
+	return ""
+}
+
+func (t *Torrent) PieceLength() int64 {
+	return t.info["piece length"].AsInt()
+}
+
+// the hashes are sliced out of the string without copying them all
+func (t *Torrent) PieceCount() int {
+	return len(t.info["pieces"].AsString()) / 20
+}
+
+func (t *Torrent) PieceHash(index int) []byte {
+	pieces := t.info["pieces"].AsString()
+	return []byte(pieces[index*20 : index*20+20])
+}
+
+// PieceSize is the piece length, except for a shorter last piece.
+func (t *Torrent) PieceSize(index int) int64 {
+	pieceLength := t.PieceLength()
+	if index == t.PieceCount()-1 {
+		if rest := t.TotalLength() - int64(index)*pieceLength; rest < pieceLength {
+			return rest
+		}
+	}
+	return pieceLength
+}
+
+func (t *Torrent) IsMultiFile() bool {
+	_, ok := t.info["files"]
+	return ok
+}
+
+// Files lists the torrent content, padding entries included since they
+// take up offsets. A single-file torrent yields one entry with an empty Path.
+func (t *Torrent) Files() []FileEntry {
+	if !t.IsMultiFile() {
+		fe := FileEntry{Length: t.info["length"].AsInt()}
+		fileAttrs(t.info, &fe)
+		return []FileEntry{fe}
+	}
+	var rvs []FileEntry
+	var offset int64
+	for i, file := range t.info["files"].AsList() {
+		fi := file.AsMap()
+		var raw [][]byte
+		for _, p := range fi["path"].AsList() {
+			raw = append(raw, p.AsBinary())
+		}
+		length := fi["length"].AsInt()
+		fe := FileEntry{
+			Index:   i,
+			Path:    t.pathComponents(fi),
+			RawPath: raw,
+			Length:  length,
+			Offset:  offset,
+		}
+		fileAttrs(fi, &fe)
+		rvs = append(rvs, fe)
+		offset += length
+	}
+	return rvs
+}
+
+// TotalLength works for single-file torrents as well, unlike GetTotalLength.
+func (t *Torrent) TotalLength() int64 {
+	var tot int64
+	for _, fe := range t.Files() {
+		tot += fe.Length
+	}
+	return tot
+}
+
+// Announces returns the trackers, announce-list tiers flattened.
+func (t *Torrent) Announces() []string {
+	var rvs []string
+	seen := make(map[string]bool)
+	add := func(n BNode) {
+		if n.IsBytes() && !seen[n.AsString()] {
+			seen[n.AsString()] = true
+			rvs = append(rvs, n.AsString())
+		}
+	}
+	meta := t.Meta()
+	if a, ok := meta["announce"]; ok {
+		add(a)
+	}
+	if al, ok := meta["announce-list"]; ok && al.Cat == BNodeList {
+		for _, tier := range al.List {
+			if tier.Cat == BNodeList {
+				for _, a := range tier.List {
+					add(a)
+				}
+			}
+		}
+	}
+	return rvs
+}
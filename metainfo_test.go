@@ -0,0 +1,151 @@
+package bencode
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testFile struct {
+	path []string
+	data []byte
+}
+
+// buildTestTorrent returns a multi-file .torrent for files, hashed with
+// pieceLength, and the concatenated content.
+func buildTestTorrent(name string, pieceLength int64, files []testFile) ([]byte, []byte) {
+	var all []byte
+	var fileList []BNode
+	for _, f := range files {
+		var ps []BNode
+		for _, p := range f.path {
+			ps = append(ps, NewString(p))
+		}
+		fileList = append(fileList, BNode{Map: map[string]BNode{
+			"length": NewInt(int64(len(f.data))),
+			"path":   {List: ps, Cat: BNodeList},
+		}, Cat: BNodeMap})
+		all = append(all, f.data...)
+	}
+	var pieces []byte
+	for off := int64(0); off < int64(len(all)); off += pieceLength {
+		end := off + pieceLength
+		if end > int64(len(all)) {
+			end = int64(len(all))
+		}
+		h := sha1.Sum(all[off:end])
+		pieces = append(pieces, h[:]...)
+	}
+	info := map[string]BNode{
+		"name":         NewString(name),
+		"piece length": NewInt(pieceLength),
+		"pieces":       NewBytes(pieces),
+		"files":        {List: fileList, Cat: BNodeList},
+	}
+	top := map[string]BNode{
+		"announce": NewString("http://tracker.example/announce"),
+		"comment":  NewString("test torrent"),
+		"info":     {Map: info, Cat: BNodeMap},
+	}
+	return Encode(BNode{Map: top, Cat: BNodeMap}), all
+}
+
+// writeTestFiles lays files out under dir/name.
+func writeTestFiles(t *testing.T, dir, name string, files []testFile) {
+	for _, f := range files {
+		p := filepath.Join(append([]string{dir, name}, f.path...)...)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, f.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func patternData(n int, seed byte) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i*7) + seed
+	}
+	return b
+}
+
+func TestParseTorrentInvalidInfo(t *testing.T) {
+	pieces := "6:pieces20:" + string(make([]byte, 20))
+	for _, c := range []struct{ name, info string }{
+		{"no piece length", "d6:lengthi1e4:name1:x" + pieces + "e"},
+		{"piece length string", "d6:lengthi1e4:name1:x12:piece length2:16" + pieces + "e"},
+		{"zero piece length", "d6:lengthi1e4:name1:x12:piece lengthi0e" + pieces + "e"},
+		{"negative piece length", "d6:lengthi1e4:name1:x12:piece lengthi-1e" + pieces + "e"},
+		{"no pieces", "d6:lengthi1e4:name1:x12:piece lengthi16ee"},
+		{"pieces integer", "d6:lengthi1e4:name1:x12:piece lengthi16e6:piecesi0ee"},
+		{"short pieces", "d6:lengthi1e4:name1:x12:piece lengthi16e6:pieces19:" + string(make([]byte, 19)) + "e"},
+		{"no length or files", "d4:name1:x12:piece lengthi16e" + pieces + "e"},
+		{"length string", "d6:length1:14:name1:x12:piece lengthi16e" + pieces + "e"},
+		{"negative length", "d6:lengthi-1e4:name1:x12:piece lengthi16e" + pieces + "e"},
+		{"files not a list", "d5:filesi0e4:name1:x12:piece lengthi16e" + pieces + "e"},
+		{"empty files", "d5:filesle4:name1:x12:piece lengthi16e" + pieces + "e"},
+		{"file not a dictionary", "d5:filesli0ee4:name1:x12:piece lengthi16e" + pieces + "e"},
+		{"file without length", "d5:filesld4:pathl1:aeee4:name1:x12:piece lengthi16e" + pieces + "e"},
+		{"file length string", "d5:filesld6:length1:14:pathl1:aeee4:name1:x12:piece lengthi16e" + pieces + "e"},
+		{"negative file length", "d5:filesld6:lengthi-1e4:pathl1:aeee4:name1:x12:piece lengthi16e" + pieces + "e"},
+		{"file without path", "d5:filesld6:lengthi1eee4:name1:x12:piece lengthi16e" + pieces + "e"},
+		{"path string", "d5:filesld6:lengthi1e4:path1:aee4:name1:x12:piece lengthi16e" + pieces + "e"},
+		{"path integer component", "d5:filesld6:lengthi1e4:pathli0eeee4:name1:x12:piece lengthi16e" + pieces + "e"},
+	} {
+		if _, err := ParseTorrent([]byte("d4:info" + c.info + "e")); !errors.Is(err, MetainfoError) {
+			t.Errorf("%v: ParseTorrent: %v", c.name, err)
+		}
+		if _, err := NewTorrentFromInfoBytes([]byte(c.info)); !errors.Is(err, MetainfoError) {
+			t.Errorf("%v: NewTorrentFromInfoBytes: %v", c.name, err)
+		}
+	}
+	ok := "d6:lengthi1e4:name1:x12:piece lengthi16e" + pieces + "e"
+	if _, err := ParseTorrent([]byte("d4:info" + ok + "e")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseTorrent(t *testing.T) {
+	raw, all := buildTestTorrent("sample", 1024, []testFile{
+		{[]string{"a.bin"}, patternData(3000, 1)},
+		{[]string{"sub", "b.bin"}, patternData(1500, 2)},
+	})
+	tr, err := ParseTorrent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawInfo, _ := rawDictValue(raw, "info")
+	if tr.InfoHash() != sha1.Sum(rawInfo) {
+		t.Fatalf("info-hash mismatch")
+	}
+	if !bytes.Equal(tr.Bytes(), raw) {
+		t.Fatalf("re-encoding changed the file")
+	}
+	if tr.TotalLength() != int64(len(all)) || tr.PieceCount() != 5 || tr.PieceSize(4) != 4500-4096 {
+		t.Fatalf("geometry: %v %v %v", tr.TotalLength(), tr.PieceCount(), tr.PieceSize(4))
+	}
+	files := tr.Files()
+	if len(files) != 2 || files[1].Offset != 3000 || files[1].Path[1] != "b.bin" {
+		t.Fatalf("files: %+v", files)
+	}
+
+	m, err := ParseMagnet(tr.Magnet().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.InfoHash != tr.InfoHash() || m.DisplayName != "sample" || len(m.Trackers) != 1 {
+		t.Fatalf("magnet: %+v", m)
+	}
+	b32, err := ParseMagnet("magnet:?xt=urn:btih:" + "AAAQEAYEAUDAOCAJBIFQYDIOB4IBCEQT")
+	if err != nil || b32.InfoHash[19] != 19 {
+		t.Fatalf("base32 magnet: %v %v", b32, err)
+	}
+	if _, err := ParseMagnet("http://example.com"); err == nil {
+		t.Fatalf("expect an error")
+	}
+}
@@ -0,0 +1,376 @@
+package bencode
+
+// Message Stream Encryption (Protocol Encryption)
+// http://wiki.vuze.com/w/Message_Stream_Encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"time"
+)
+
+type CryptoMethod uint32
+
+const (
+	CryptoPlaintext CryptoMethod = 0x01
+	CryptoRC4       CryptoMethod = 0x02
+
+	mseKeyLength = 96
+	mseMaxPad    = 512
+)
+
+var (
+	MSEHandshakeError = errors.New("encryption handshake failed")
+	MSESkeyError      = errors.New("encryption handshake for an unknown info-hash")
+	MSECryptoError    = errors.New("no common crypto method")
+
+	msePrime, _ = new(big.Int).SetString(
+		"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74"+
+			"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437"+
+			"4FE1356D6D51C245E485B576625E7EC6F44C42E9A63A36210000000000090563", 16)
+	mseGenerator = big.NewInt(2)
+	mseVC        = make([]byte, 8)
+)
+
+func (m CryptoMethod) String() string {
+	switch m {
+	case CryptoPlaintext:
+		return "plaintext"
+	case CryptoRC4:
+		return "rc4"
+	}
+	return fmt.Sprintf("crypto<%#x>", uint32(m))
+}
+
+// MSEConn is a peer connection after the encryption handshake. With the
+// plaintext method only the handshake itself was obfuscated.
+type MSEConn struct {
+	net.Conn
+	Method   CryptoMethod
+	InfoHash [20]byte
+
+	r       *bufio.Reader
+	dec     *rc4.Cipher
+	enc     *rc4.Cipher
+	pending []byte // initial payload from the initiator, already decrypted
+}
+
+func (c *MSEConn) Read(p []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	n, err := c.r.Read(p)
+	if c.Method == CryptoRC4 && n > 0 {
+		c.dec.XORKeyStream(p[:n], p[:n])
+	}
+	return n, err
+}
+
+func (c *MSEConn) Write(p []byte) (int, error) {
+	if c.Method != CryptoRC4 {
+		return c.Conn.Write(p)
+	}
+	buf := make([]byte, len(p))
+	c.enc.XORKeyStream(buf, p)
+	return c.Conn.Write(buf)
+}
+
+func mseHash(parts ...[]byte) []byte {
+	h := sha1.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+func xorBytes(a, b []byte) []byte {
+	rv := make([]byte, len(a))
+	for i := range a {
+		rv[i] = a[i] ^ b[i]
+	}
+	return rv
+}
+
+// RC4 keyed with HASH(name, S, SKEY), first 1024 bytes discarded
+func mseCipher(name string, secret []byte, skey [20]byte) *rc4.Cipher {
+	c, _ := rc4.NewCipher(mseHash([]byte(name), secret, skey[:]))
+	discard := make([]byte, 1024)
+	c.XORKeyStream(discard, discard)
+	return c
+}
+
+func mseKeyPair() (*big.Int, []byte, error) {
+	xb := make([]byte, 20)
+	if _, err := rand.Read(xb); err != nil {
+		return nil, nil, err
+	}
+	x := new(big.Int).SetBytes(xb)
+	y := new(big.Int).Exp(mseGenerator, x, msePrime)
+	return x, padKey(y), nil
+}
+
+func padKey(v *big.Int) []byte {
+	b := v.Bytes()
+	out := make([]byte, mseKeyLength)
+	copy(out[mseKeyLength-len(b):], b)
+	return out
+}
+
+func mseSecret(x *big.Int, remote []byte) ([]byte, error) {
+	y := new(big.Int).SetBytes(remote)
+	if y.Cmp(big.NewInt(1)) <= 0 || y.Cmp(msePrime) >= 0 {
+		return nil, fmt.Errorf("%w: bad public key", MSEHandshakeError)
+	}
+	return padKey(new(big.Int).Exp(y, x, msePrime)), nil
+}
+
+func msePad() []byte {
+	var n [2]byte
+	rand.Read(n[:])
+	pad := make([]byte, int(binary.BigEndian.Uint16(n[:]))%(mseMaxPad+1))
+	rand.Read(pad)
+	return pad
+}
+
+// syncTo reads until pattern has been seen, at most limit bytes in all.
+func syncTo(r *bufio.Reader, pattern []byte, limit int) error {
+	window := make([]byte, 0, limit)
+	for len(window) < limit {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		window = append(window, b)
+		if bytes.HasSuffix(window, pattern) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: synchronization lost", MSEHandshakeError)
+}
+
+// MSEInitiate runs the outgoing side of the handshake. provide lists the
+// methods we accept; the receiver picks one.
+func MSEInitiate(conn net.Conn, infoHash [20]byte, provide CryptoMethod, timeout time.Duration) (*MSEConn, error) {
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+	x, ya, err := mseKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(ya, msePad()...)); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	yb := make([]byte, mseKeyLength)
+	if _, err := io.ReadFull(r, yb); err != nil {
+		return nil, err
+	}
+	secret, err := mseSecret(x, yb)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := mseCipher("keyA", secret, infoHash)
+	dec := mseCipher("keyB", secret, infoHash)
+
+	var msg bytes.Buffer
+	msg.Write(mseHash([]byte("req1"), secret))
+	msg.Write(xorBytes(mseHash([]byte("req2"), infoHash[:]), mseHash([]byte("req3"), secret)))
+	padC := msePad()
+	plain := make([]byte, 0, 8+4+2+len(padC)+2)
+	plain = append(plain, mseVC...)
+	plain = binary.BigEndian.AppendUint32(plain, uint32(provide))
+	plain = binary.BigEndian.AppendUint16(plain, uint16(len(padC)))
+	plain = append(plain, padC...)
+	plain = binary.BigEndian.AppendUint16(plain, 0) // no initial payload
+	enc.XORKeyStream(plain, plain)
+	msg.Write(plain)
+	if _, err := conn.Write(msg.Bytes()); err != nil {
+		return nil, err
+	}
+
+	// PadB is in the way, look for ENCRYPT(VC)
+	encVC := make([]byte, 8)
+	dec.XORKeyStream(encVC, mseVC)
+	if err := syncTo(r, encVC, mseMaxPad+8); err != nil {
+		return nil, err
+	}
+	head := make([]byte, 6)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	dec.XORKeyStream(head, head)
+	method := CryptoMethod(binary.BigEndian.Uint32(head))
+	padD := make([]byte, binary.BigEndian.Uint16(head[4:]))
+	if len(padD) > mseMaxPad {
+		return nil, fmt.Errorf("%w: padD of %v byte(s)", MSEHandshakeError, len(padD))
+	}
+	if _, err := io.ReadFull(r, padD); err != nil {
+		return nil, err
+	}
+	dec.XORKeyStream(padD, padD)
+	if method != CryptoPlaintext && method != CryptoRC4 || method&provide == 0 {
+		return nil, fmt.Errorf("%w: peer selected %v", MSECryptoError, method)
+	}
+	return &MSEConn{Conn: conn, Method: method, InfoHash: infoHash, r: r, dec: dec, enc: enc}, nil
+}
+
+// MSEAccept runs the incoming side. The initiator names its torrent only
+// through a hash, so every info-hash we serve is tried. allowed lists the
+// methods we are willing to select, RC4 preferred.
+func MSEAccept(conn net.Conn, infoHashes [][20]byte, allowed CryptoMethod, timeout time.Duration) (*MSEConn, error) {
+	return mseAccept(conn, bufio.NewReader(conn), infoHashes, allowed, timeout)
+}
+
+func mseAccept(conn net.Conn, r *bufio.Reader, infoHashes [][20]byte, allowed CryptoMethod, timeout time.Duration) (*MSEConn, error) {
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+	ya := make([]byte, mseKeyLength)
+	if _, err := io.ReadFull(r, ya); err != nil {
+		return nil, err
+	}
+	x, yb, err := mseKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := mseSecret(x, ya)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(yb, msePad()...)); err != nil {
+		return nil, err
+	}
+
+	// PadA is in the way, look for HASH('req1', S)
+	if err := syncTo(r, mseHash([]byte("req1"), secret), mseMaxPad+20); err != nil {
+		return nil, err
+	}
+	obfuscated := make([]byte, 20)
+	if _, err := io.ReadFull(r, obfuscated); err != nil {
+		return nil, err
+	}
+	req2 := xorBytes(obfuscated, mseHash([]byte("req3"), secret))
+	var skey [20]byte
+	found := false
+	for _, ih := range infoHashes {
+		if bytes.Equal(mseHash([]byte("req2"), ih[:]), req2) {
+			skey, found = ih, true
+			break
+		}
+	}
+	if !found {
+		return nil, MSESkeyError
+	}
+
+	dec := mseCipher("keyA", secret, skey)
+	enc := mseCipher("keyB", secret, skey)
+	head := make([]byte, 8+4+2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	dec.XORKeyStream(head, head)
+	if !bytes.Equal(head[:8], mseVC) {
+		return nil, fmt.Errorf("%w: bad verification constant", MSEHandshakeError)
+	}
+	provide := CryptoMethod(binary.BigEndian.Uint32(head[8:]))
+	padC := make([]byte, binary.BigEndian.Uint16(head[12:]))
+	if len(padC) > mseMaxPad {
+		return nil, fmt.Errorf("%w: padC of %v byte(s)", MSEHandshakeError, len(padC))
+	}
+	if _, err := io.ReadFull(r, padC); err != nil {
+		return nil, err
+	}
+	dec.XORKeyStream(padC, padC)
+	var lenIA [2]byte
+	if _, err := io.ReadFull(r, lenIA[:]); err != nil {
+		return nil, err
+	}
+	dec.XORKeyStream(lenIA[:], lenIA[:])
+	ia := make([]byte, binary.BigEndian.Uint16(lenIA[:]))
+	if _, err := io.ReadFull(r, ia); err != nil {
+		return nil, err
+	}
+	dec.XORKeyStream(ia, ia)
+
+	var method CryptoMethod
+	switch common := provide & allowed; {
+	case common&CryptoRC4 != 0:
+		method = CryptoRC4
+	case common&CryptoPlaintext != 0:
+		method = CryptoPlaintext
+	default:
+		return nil, fmt.Errorf("%w: peer provides %#x", MSECryptoError, uint32(provide))
+	}
+
+	padD := msePad()
+	reply := make([]byte, 0, 8+4+2+len(padD))
+	reply = append(reply, mseVC...)
+	reply = binary.BigEndian.AppendUint32(reply, uint32(method))
+	reply = binary.BigEndian.AppendUint16(reply, uint16(len(padD)))
+	reply = append(reply, padD...)
+	enc.XORKeyStream(reply, reply)
+	if _, err := conn.Write(reply); err != nil {
+		return nil, err
+	}
+	return &MSEConn{Conn: conn, Method: method, InfoHash: skey, r: r, dec: dec, enc: enc, pending: ia}, nil
+}
+
+// MSEDialer returns a DialFunc that encrypts every outgoing connection.
+func MSEDialer(infoHash [20]byte, provide CryptoMethod) DialFunc {
+	return func(addr string, timeout time.Duration) (net.Conn, error) {
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		mc, err := MSEInitiate(conn, infoHash, provide, timeout)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return mc, nil
+	}
+}
+
+// bufferedConn replays what was read ahead while sniffing the protocol.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// AcceptMaybeEncrypted tells a plaintext BitTorrent handshake from an
+// encrypted one by its first bytes and returns a connection that carries
+// the plain peer protocol either way.
+func AcceptMaybeEncrypted(conn net.Conn, infoHashes [][20]byte, allowed CryptoMethod, timeout time.Duration) (net.Conn, error) {
+	r := bufio.NewReader(conn)
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+	head, err := r.Peek(20)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	if head[0] == byte(len(ProtocolName)) && string(head[1:20]) == ProtocolName {
+		return &bufferedConn{Conn: conn, r: r}, nil
+	}
+	return mseAccept(conn, r, infoHashes, allowed, timeout)
+}
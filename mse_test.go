@@ -0,0 +1,123 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func mseLoopback(t *testing.T, accept func(conn net.Conn)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go accept(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestMSEHandshake(t *testing.T) {
+	ih := [20]byte{9, 8, 7}
+	other := [20]byte{1}
+
+	type result struct {
+		method CryptoMethod
+		got    []byte
+		err    error
+	}
+	results := make(chan result, 1)
+	addr := mseLoopback(t, func(conn net.Conn) {
+		defer conn.Close()
+		mc, err := MSEAccept(conn, [][20]byte{other, ih}, CryptoRC4|CryptoPlaintext, 5*time.Second)
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		buf := make([]byte, 11)
+		_, err = io.ReadFull(mc, buf)
+		mc.Write([]byte("pong"))
+		results <- result{method: mc.Method, got: buf, err: err}
+	})
+
+	for _, provide := range []CryptoMethod{CryptoRC4 | CryptoPlaintext, CryptoPlaintext} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mc, err := MSEInitiate(conn, ih, provide, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mc.Write([]byte("hello world"))
+		reply := make([]byte, 4)
+		if _, err := io.ReadFull(mc, reply); err != nil || string(reply) != "pong" {
+			t.Fatalf("reply %q: %v", reply, err)
+		}
+		r := <-results
+		if r.err != nil || string(r.got) != "hello world" {
+			t.Fatalf("receiver: %+v", r)
+		}
+		want := CryptoRC4
+		if provide == CryptoPlaintext {
+			want = CryptoPlaintext
+		}
+		if mc.Method != want || r.method != want {
+			t.Fatalf("selected %v/%v, want %v", mc.Method, r.method, want)
+		}
+		mc.Close()
+	}
+
+	// unknown info-hash
+	conn, _ := net.Dial("tcp", addr)
+	defer conn.Close()
+	go MSEInitiate(conn, [20]byte{0xff}, CryptoRC4, 5*time.Second)
+	if r := <-results; !errors.Is(r.err, MSESkeyError) {
+		t.Fatalf("expect MSESkeyError, got %v", r.err)
+	}
+}
+
+func TestMSEWithSeeder(t *testing.T) {
+	files := []testFile{{[]string{"enc.bin"}, patternData(70000, 4)}}
+	raw, _ := buildTestTorrent("enc", 32*1024, files)
+	tr, _ := ParseTorrent(raw)
+	seedDir := t.TempDir()
+	writeTestFiles(t, seedDir, "enc", files)
+
+	s := NewSeeder()
+	s.Encryption = CryptoRC4
+	defer s.Close()
+	if _, err := s.AddTorrent(tr, seedDir); err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := s.Listen("127.0.0.1:0")
+
+	// an encrypting downloader
+	dir := t.TempDir()
+	d := NewDownloader(tr, dir, []string{addr.String()})
+	d.Dial = MSEDialer(tr.InfoHash(), CryptoRC4)
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	checkTestFiles(t, dir, "enc", files)
+
+	// plain peers are still welcome
+	pc, _, err := DialPeer(addr.String(), Handshake{InfoHash: tr.InfoHash()}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	if m, err := pc.ReadMessage(); err != nil || m.ID != MsgBitfield || !bytes.Equal(m.Bitfield, []byte{0xe0}) {
+		t.Fatalf("bitfield: %v %v", m, err)
+	}
+}
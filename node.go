@@ -0,0 +1,146 @@
+package bencode
+
+func NewString(s string) BNode {
+	return BNode{Str: &s, Cat: BNodeString}
+}
+
+// NewBytes makes a byte string node holding a copy of b.
+func NewBytes(b []byte) BNode {
+	return NewString(string(b))
+}
+
+func NewInt(v int64) BNode {
+	return BNode{Int: &v, Cat: BNodeInteger}
+}
+
+// NewList makes a list of the given items; the items are not cloned.
+func NewList(items ...BNode) BNode {
+	return BNode{List: append([]BNode{}, items...), Cat: BNodeList}
+}
+
+// NewDict makes a dictionary holding the entries of m, which may be nil;
+// the values are not cloned.
+func NewDict(m map[string]BNode) BNode {
+	d := make(map[string]BNode, len(m))
+	for k, v := range m {
+		d[k] = v
+	}
+	return BNode{Map: d, Cat: BNodeMap}
+}
+
+// Set stores v under key in a dictionary.
+func (b *BNode) Set(key string, v BNode) {
+	if b.Cat != BNodeMap {
+		panic(TypeError)
+	}
+	if b.Map == nil {
+		b.Map = make(map[string]BNode)
+	}
+	b.Map[key] = v
+}
+
+// Delete removes key from a dictionary and tells whether it was there.
+func (b *BNode) Delete(key string) bool {
+	if b.Cat != BNodeMap {
+		panic(TypeError)
+	}
+	_, ok := b.Map[key]
+	delete(b.Map, key)
+	return ok
+}
+
+// Append adds items to the end of a list.
+func (b *BNode) Append(items ...BNode) {
+	if b.Cat != BNodeList {
+		panic(TypeError)
+	}
+	b.List = append(b.List, items...)
+}
+
+// SetIndex replaces an item of a list; negative indexes count from the
+// end.
+func (b *BNode) SetIndex(i int, v BNode) {
+	b.List[b.listIndex(i)] = v
+}
+
+// DeleteIndex removes an item of a list; negative indexes count from the
+// end.
+func (b *BNode) DeleteIndex(i int) {
+	i = b.listIndex(i)
+	b.List = append(b.List[:i:i], b.List[i+1:]...)
+}
+
+func (b *BNode) listIndex(i int) int {
+	if b.Cat != BNodeList {
+		panic(TypeError)
+	}
+	if i < 0 {
+		i += len(b.List)
+	}
+	if i < 0 || i >= len(b.List) {
+		panic(IndexError)
+	}
+	return i
+}
+
+// Clone makes a deep copy of b that shares nothing with it.
+func (b BNode) Clone() BNode {
+	c := BNode{Cat: b.Cat}
+	if b.Str != nil {
+		s := *b.Str
+		c.Str = &s
+	}
+	if b.Int != nil {
+		v := *b.Int
+		c.Int = &v
+	}
+	if b.List != nil {
+		c.List = make([]BNode, len(b.List))
+		for i, el := range b.List {
+			c.List[i] = el.Clone()
+		}
+	}
+	if b.Map != nil {
+		c.Map = make(map[string]BNode, len(b.Map))
+		for k, v := range b.Map {
+			c.Map[k] = v.Clone()
+		}
+	}
+	return c
+}
+
+// Equal tells whether a and b hold the same value, that is whether they
+// encode the same. Empty lists and dictionaries equal nil ones.
+func (b BNode) Equal(o BNode) bool {
+	if b.Cat != o.Cat {
+		return false
+	}
+	switch b.Cat {
+	case BNodeString:
+		return *b.Str == *o.Str
+	case BNodeInteger:
+		return *b.Int == *o.Int
+	case BNodeList:
+		if len(b.List) != len(o.List) {
+			return false
+		}
+		for i := range b.List {
+			if !b.List[i].Equal(o.List[i]) {
+				return false
+			}
+		}
+		return true
+	case BNodeMap:
+		if len(b.Map) != len(o.Map) {
+			return false
+		}
+		for k, v := range b.Map {
+			ov, ok := o.Map[k]
+			if !ok || !v.Equal(ov) {
+				return false
+			}
+		}
+		return true
+	}
+	return b.Cat == o.Cat
+}
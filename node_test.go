@@ -0,0 +1,46 @@
+package bencode
+
+import (
+	"testing"
+)
+
+func TestNodeEditing(t *testing.T) {
+	d := NewDict(nil)
+	d.Set("name", NewString("x"))
+	d.Set("tiers", NewList(NewList(NewString("t/1")), NewList()))
+	d.Set("blob", NewBytes([]byte{0xff, 0}))
+	d.Set("n", NewInt(7))
+	if got := string(Encode(d)); got != "d4:blob2:\xff\x001:ni7e4:name1:x5:tiersll3:t/1eleee" {
+		t.Fatalf("encoded %q", got)
+	}
+
+	c := d.Clone()
+	if !c.Equal(d) || !d.Equal(MustScan(Encode(d))) {
+		t.Fatal("clone differs")
+	}
+	tiers := c.Map["tiers"]
+	tiers.Append(NewList(NewString("t/2")))
+	tiers.List[0].SetIndex(-1, NewString("t/0"))
+	tiers.DeleteIndex(1)
+	c.Set("tiers", tiers)
+	*c.Map["n"].Int = 8
+	if !c.Delete("blob") || c.Delete("blob") {
+		t.Fatal("delete")
+	}
+	if got := string(Encode(d)); got != "d4:blob2:\xff\x001:ni7e4:name1:x5:tiersll3:t/1eleee" {
+		t.Fatalf("original changed: %q", got)
+	}
+	if got := string(Encode(c)); got != "d1:ni8e4:name1:x5:tiersll3:t/0el3:t/2eee" {
+		t.Fatalf("edited %q", got)
+	}
+	if c.Equal(d) || NewInt(1).Equal(NewString("1")) {
+		t.Fatal("unequal nodes compare equal")
+	}
+	if !NewString("ab").Equal(NewBytes([]byte("ab"))) {
+		t.Fatal("byte strings compare by content")
+	}
+
+	l := NewList()
+	doExpect(t, func() { l.Set("k", NewInt(1)) }, TypeError)
+	doExpect(t, func() { l.DeleteIndex(0) }, IndexError)
+}
@@ -0,0 +1,446 @@
+package bencode
+
+// BitTorrent peer wire protocol
+// http://bittorrent.org/beps/bep_0003.html#peer-messages
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	MsgChoke = iota
+	MsgUnchoke
+	MsgInterested
+	MsgNotInterested
+	MsgHave
+	MsgBitfield
+	MsgRequest
+	MsgPiece
+	MsgCancel
+	MsgPort
+)
+
+const (
+	ProtocolName  = "BitTorrent protocol"
+	ClientVersion = "gobencode 0.1"
+
+	// 1 + 19 + 8 + 20 + 20
+	HandshakeLength = 68
+
+	// the customary block size, and the largest one we are going to serve
+	BlockSize = 16 * 1024
+
+	// a piece message carrying a full block, with some room to spare
+	DefaultMaxMessageSize = BlockSize + 128*1024
+)
+
+var (
+	HandshakeError      = errors.New("invalid handshake")
+	InfoHashError       = errors.New("info-hash mismatch")
+	MessageTooLongError = errors.New("message exceeds maximum size")
+	MessageFormatError  = errors.New("malformed peer message")
+)
+
+type Handshake struct {
+	Reserved [8]byte
+	InfoHash [20]byte
+	PeerID   [20]byte
+}
+
+func (h Handshake) MarshalBinary() []byte {
+	buf := make([]byte, 0, HandshakeLength)
+	buf = append(buf, byte(len(ProtocolName)))
+	buf = append(buf, ProtocolName...)
+	buf = append(buf, h.Reserved[:]...)
+	buf = append(buf, h.InfoHash[:]...)
+	buf = append(buf, h.PeerID[:]...)
+	return buf
+}
+
+func WriteHandshake(w io.Writer, h Handshake) error {
+	_, err := w.Write(h.MarshalBinary())
+	return err
+}
+
+func ReadHandshake(r io.Reader) (Handshake, error) {
+	var h Handshake
+	buf := make([]byte, HandshakeLength)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return h, err
+	}
+	if int(buf[0]) != len(ProtocolName) || string(buf[1:20]) != ProtocolName {
+		return h, HandshakeError
+	}
+	copy(h.Reserved[:], buf[20:28])
+	copy(h.InfoHash[:], buf[28:48])
+	copy(h.PeerID[:], buf[48:68])
+	return h, nil
+}
+
+// Message is one length-prefixed peer message.
+// Only the fields relevant to ID are meaningful.
+type Message struct {
+	KeepAlive bool
+	ID        byte
+
+	// have, request, piece, cancel
+	Index  uint32
+	Begin  uint32
+	Length uint32
+
+	Bitfield []byte
+	Block    []byte
+	Port     uint16
+
+	// raw payload for message types this codec does not interpret
+	Payload []byte
+}
+
+func KeepAliveMessage() Message {
+	return Message{KeepAlive: true}
+}
+
+func ChokeMessage() Message         { return Message{ID: MsgChoke} }
+func UnchokeMessage() Message       { return Message{ID: MsgUnchoke} }
+func InterestedMessage() Message    { return Message{ID: MsgInterested} }
+func NotInterestedMessage() Message { return Message{ID: MsgNotInterested} }
+
+func HaveMessage(index uint32) Message {
+	return Message{ID: MsgHave, Index: index}
+}
+
+func BitfieldMessage(bf []byte) Message {
+	return Message{ID: MsgBitfield, Bitfield: bf}
+}
+
+func RequestMessage(index, begin, length uint32) Message {
+	return Message{ID: MsgRequest, Index: index, Begin: begin, Length: length}
+}
+
+func PieceMessage(index, begin uint32, block []byte) Message {
+	return Message{ID: MsgPiece, Index: index, Begin: begin, Block: block}
+}
+
+func CancelMessage(index, begin, length uint32) Message {
+	return Message{ID: MsgCancel, Index: index, Begin: begin, Length: length}
+}
+
+func PortMessage(port uint16) Message {
+	return Message{ID: MsgPort, Port: port}
+}
+
+func (m Message) String() string {
+	if m.KeepAlive {
+		return "keep-alive"
+	}
+	switch m.ID {
+	case MsgChoke:
+		return "choke"
+	case MsgUnchoke:
+		return "unchoke"
+	case MsgInterested:
+		return "interested"
+	case MsgNotInterested:
+		return "not-interested"
+	case MsgHave:
+		return fmt.Sprintf("have(%v)", m.Index)
+	case MsgBitfield:
+		return fmt.Sprintf("bitfield(%v byte(s))", len(m.Bitfield))
+	case MsgRequest:
+		return fmt.Sprintf("request(%v,%v,%v)", m.Index, m.Begin, m.Length)
+	case MsgPiece:
+		return fmt.Sprintf("piece(%v,%v,%v byte(s))", m.Index, m.Begin, len(m.Block))
+	case MsgCancel:
+		return fmt.Sprintf("cancel(%v,%v,%v)", m.Index, m.Begin, m.Length)
+	case MsgPort:
+		return fmt.Sprintf("port(%v)", m.Port)
+	case MsgExtended:
+		if len(m.Payload) > 0 {
+			return fmt.Sprintf("extended<%v>(%v byte(s))", m.Payload[0], len(m.Payload)-1)
+		}
+	}
+	return fmt.Sprintf("message<%v>(%v byte(s))", m.ID, len(m.Payload))
+}
+
+// payload without the length prefix and id
+func (m Message) body() []byte {
+	switch m.ID {
+	case MsgChoke, MsgUnchoke, MsgInterested, MsgNotInterested:
+		return nil
+	case MsgHave:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, m.Index)
+		return b
+	case MsgBitfield:
+		return m.Bitfield
+	case MsgRequest, MsgCancel:
+		b := make([]byte, 12)
+		binary.BigEndian.PutUint32(b[0:], m.Index)
+		binary.BigEndian.PutUint32(b[4:], m.Begin)
+		binary.BigEndian.PutUint32(b[8:], m.Length)
+		return b
+	case MsgPiece:
+		b := make([]byte, 8, 8+len(m.Block))
+		binary.BigEndian.PutUint32(b[0:], m.Index)
+		binary.BigEndian.PutUint32(b[4:], m.Begin)
+		return append(b, m.Block...)
+	case MsgPort:
+		b := make([]byte, 2)
+		binary.BigEndian.PutUint16(b, m.Port)
+		return b
+	}
+	return m.Payload
+}
+
+func (m Message) MarshalBinary() []byte {
+	if m.KeepAlive {
+		return make([]byte, 4)
+	}
+	body := m.body()
+	buf := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(buf, uint32(1+len(body)))
+	buf[4] = m.ID
+	return append(buf, body...)
+}
+
+// ParseMessage decodes a message from its frame, the length prefix excluded.
+func ParseMessage(frame []byte) (Message, error) {
+	if len(frame) == 0 {
+		return KeepAliveMessage(), nil
+	}
+	m := Message{ID: frame[0]}
+	body := frame[1:]
+
+	expect := func(n int) error {
+		if len(body) != n {
+			return fmt.Errorf("%w: %v with %v byte(s) payload", MessageFormatError, m.String(), len(body))
+		}
+		return nil
+	}
+
+	switch m.ID {
+	case MsgChoke, MsgUnchoke, MsgInterested, MsgNotInterested:
+		if err := expect(0); err != nil {
+			return m, err
+		}
+	case MsgHave:
+		if err := expect(4); err != nil {
+			return m, err
+		}
+		m.Index = binary.BigEndian.Uint32(body)
+	case MsgBitfield:
+		m.Bitfield = body
+	case MsgRequest, MsgCancel:
+		if err := expect(12); err != nil {
+			return m, err
+		}
+		m.Index = binary.BigEndian.Uint32(body[0:])
+		m.Begin = binary.BigEndian.Uint32(body[4:])
+		m.Length = binary.BigEndian.Uint32(body[8:])
+	case MsgPiece:
+		if len(body) < 8 {
+			return m, fmt.Errorf("%w: piece with %v byte(s) payload", MessageFormatError, len(body))
+		}
+		m.Index = binary.BigEndian.Uint32(body[0:])
+		m.Begin = binary.BigEndian.Uint32(body[4:])
+		m.Block = body[8:]
+	case MsgPort:
+		if err := expect(2); err != nil {
+			return m, err
+		}
+		m.Port = binary.BigEndian.Uint16(body)
+	default:
+		m.Payload = body
+	}
+	return m, nil
+}
+
+// PeerConn frames messages over a connection.
+// Writes are serialized; reads are expected from one goroutine.
+type PeerConn struct {
+	Conn net.Conn
+
+	// frames longer than this are rejected on read and refused on write
+	MaxMessageSize int
+
+	// zero means no deadline
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	r   *bufio.Reader
+	wmu sync.Mutex
+}
+
+func NewPeerConn(conn net.Conn) *PeerConn {
+	return &PeerConn{
+		Conn:           conn,
+		MaxMessageSize: DefaultMaxMessageSize,
+		r:              bufio.NewReader(conn),
+	}
+}
+
+func (pc *PeerConn) ReadHandshake() (Handshake, error) {
+	if pc.ReadTimeout > 0 {
+		pc.Conn.SetReadDeadline(time.Now().Add(pc.ReadTimeout))
+	}
+	return ReadHandshake(pc.r)
+}
+
+func (pc *PeerConn) WriteHandshake(h Handshake) error {
+	return pc.write(h.MarshalBinary())
+}
+
+func (pc *PeerConn) ReadMessage() (Message, error) {
+	if pc.ReadTimeout > 0 {
+		pc.Conn.SetReadDeadline(time.Now().Add(pc.ReadTimeout))
+	}
+	var prefix [4]byte
+	if _, err := io.ReadFull(pc.r, prefix[:]); err != nil {
+		return Message{}, err
+	}
+	length := binary.BigEndian.Uint32(prefix[:])
+	if int64(length) > int64(pc.MaxMessageSize) {
+		return Message{}, fmt.Errorf("%w: %v > %v", MessageTooLongError, length, pc.MaxMessageSize)
+	}
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(pc.r, frame); err != nil {
+		return Message{}, err
+	}
+	return ParseMessage(frame)
+}
+
+func (pc *PeerConn) WriteMessage(m Message) error {
+	buf := m.MarshalBinary()
+	if len(buf)-4 > pc.MaxMessageSize {
+		return fmt.Errorf("%w: %v > %v", MessageTooLongError, len(buf)-4, pc.MaxMessageSize)
+	}
+	return pc.write(buf)
+}
+
+func (pc *PeerConn) write(buf []byte) error {
+	pc.wmu.Lock()
+	defer pc.wmu.Unlock()
+	if pc.WriteTimeout > 0 {
+		pc.Conn.SetWriteDeadline(time.Now().Add(pc.WriteTimeout))
+	}
+	_, err := pc.Conn.Write(buf)
+	return err
+}
+
+func (pc *PeerConn) Close() error {
+	return pc.Conn.Close()
+}
+
+// GeneratePeerID returns an Azureus-style id: -GB0001- and 12 random bytes.
+func GeneratePeerID() [20]byte {
+	var id [20]byte
+	copy(id[:], "-GB0001-")
+	rand.Read(id[8:])
+	return id
+}
+
+// InitiateHandshake sends our handshake first and checks the info-hash of
+// the one that comes back.
+func InitiateHandshake(conn net.Conn, h Handshake, timeout time.Duration) (*PeerConn, Handshake, error) {
+	pc := NewPeerConn(conn)
+	pc.ReadTimeout, pc.WriteTimeout = timeout, timeout
+	if err := pc.WriteHandshake(h); err != nil {
+		return nil, Handshake{}, err
+	}
+	remote, err := pc.ReadHandshake()
+	if err != nil {
+		return nil, remote, err
+	}
+	if remote.InfoHash != h.InfoHash {
+		return nil, remote, InfoHashError
+	}
+	return pc, remote, nil
+}
+
+func DialPeer(addr string, h Handshake, timeout time.Duration) (*PeerConn, Handshake, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, Handshake{}, err
+	}
+	pc, remote, err := InitiateHandshake(conn, h, timeout)
+	if err != nil {
+		conn.Close()
+		return nil, remote, err
+	}
+	return pc, remote, nil
+}
+
+// Bitfield is the piece-availability bitmap. The high bit of byte 0 is piece 0.
+type Bitfield []byte
+
+func NewBitfield(pieceCount int) Bitfield {
+	return make(Bitfield, (pieceCount+7)/8)
+}
+
+func (bf Bitfield) Has(index int) bool {
+	if index < 0 || index/8 >= len(bf) {
+		return false
+	}
+	return bf[index/8]&(0x80>>uint(index%8)) != 0
+}
+
+func (bf Bitfield) Set(index int) {
+	if index < 0 || index/8 >= len(bf) {
+		return
+	}
+	bf[index/8] |= 0x80 >> uint(index%8)
+}
+
+func (bf Bitfield) Clear(index int) {
+	if index < 0 || index/8 >= len(bf) {
+		return
+	}
+	bf[index/8] &^= 0x80 >> uint(index%8)
+}
+
+func (bf Bitfield) Count() int {
+	n := 0
+	for _, b := range bf {
+		for ; b != 0; b &= b - 1 {
+			n++
+		}
+	}
+	return n
+}
+
+// Missing lists the pieces below pieceCount that are not set.
+func (bf Bitfield) Missing(pieceCount int) []int {
+	var rvs []int
+	for i := 0; i < pieceCount; i++ {
+		if !bf.Has(i) {
+			rvs = append(rvs, i)
+		}
+	}
+	return rvs
+}
+
+// Validate checks the length and the spare bits at the end.
+func (bf Bitfield) Validate(pieceCount int) error {
+	if len(bf) != (pieceCount+7)/8 {
+		return fmt.Errorf("%w: bitfield of %v byte(s) for %v piece(s)", MessageFormatError, len(bf), pieceCount)
+	}
+	for i := pieceCount; i < len(bf)*8; i++ {
+		if bf.Has(i) {
+			return fmt.Errorf("%w: spare bit %v set in bitfield", MessageFormatError, i)
+		}
+	}
+	return nil
+}
+
+func (bf Bitfield) Clone() Bitfield {
+	return Bitfield(bytes.Clone(bf))
+}
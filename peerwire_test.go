@@ -0,0 +1,133 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestHandshakeOverPipe(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	var h Handshake
+	copy(h.InfoHash[:], bytes.Repeat([]byte{0xab}, 20))
+	copy(h.PeerID[:], "-GB0001-123456789012")
+	h.Reserved[5] = 0x10
+
+	go func() {
+		NewPeerConn(a).WriteHandshake(h)
+	}()
+	got, err := NewPeerConn(b).ReadHandshake()
+	if err != nil {
+		t.Fatalf("read handshake: %v", err)
+	}
+	if got != h {
+		t.Fatalf("handshake mismatch: %+v", got)
+	}
+
+	if _, err := ReadHandshake(bytes.NewReader(make([]byte, HandshakeLength))); err != HandshakeError {
+		t.Fatalf("expect HandshakeError, got %v", err)
+	}
+}
+
+func TestMessagesOverPipe(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	msgs := []Message{
+		KeepAliveMessage(),
+		ChokeMessage(),
+		UnchokeMessage(),
+		InterestedMessage(),
+		NotInterestedMessage(),
+		HaveMessage(42),
+		BitfieldMessage([]byte{0xff, 0x80}),
+		RequestMessage(1, 16384, 16384),
+		PieceMessage(1, 16384, []byte("block data")),
+		CancelMessage(1, 16384, 16384),
+		PortMessage(6881),
+		{ID: 99, Payload: []byte("unknown")},
+	}
+
+	w := NewPeerConn(a)
+	go func() {
+		for _, m := range msgs {
+			if err := w.WriteMessage(m); err != nil {
+				return
+			}
+		}
+	}()
+
+	r := NewPeerConn(b)
+	for _, want := range msgs {
+		got, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("read %v: %v", want, err)
+		}
+		if !reflect.DeepEqual(normalizeMessage(got), normalizeMessage(want)) {
+			t.Fatalf("got %#v, want %#v", got, want)
+		}
+	}
+}
+
+func normalizeMessage(m Message) Message {
+	if len(m.Bitfield) == 0 {
+		m.Bitfield = nil
+	}
+	if len(m.Block) == 0 {
+		m.Block = nil
+	}
+	if len(m.Payload) == 0 {
+		m.Payload = nil
+	}
+	return m
+}
+
+func TestMessageLimits(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	w := NewPeerConn(a)
+	w.MaxMessageSize = 1 << 20
+	r := NewPeerConn(b)
+	r.MaxMessageSize = 64
+
+	go w.WriteMessage(PieceMessage(0, 0, make([]byte, 100)))
+	if _, err := r.ReadMessage(); !errors.Is(err, MessageTooLongError) {
+		t.Fatalf("expect MessageTooLongError, got %v", err)
+	}
+
+	w.MaxMessageSize = 8
+	if err := w.WriteMessage(PieceMessage(0, 0, make([]byte, 100))); !errors.Is(err, MessageTooLongError) {
+		t.Fatalf("expect MessageTooLongError on write, got %v", err)
+	}
+
+	if _, err := ParseMessage([]byte{MsgHave, 0, 0}); !errors.Is(err, MessageFormatError) {
+		t.Fatalf("expect MessageFormatError, got %v", err)
+	}
+	if _, err := ParseMessage([]byte{MsgChoke, 1}); !errors.Is(err, MessageFormatError) {
+		t.Fatalf("expect MessageFormatError, got %v", err)
+	}
+}
+
+func TestBitfield(t *testing.T) {
+	bf := NewBitfield(10)
+	bf.Set(0)
+	bf.Set(9)
+	if !bf.Has(0) || !bf.Has(9) || bf.Has(1) || bf.Count() != 2 {
+		t.Fatalf("bitfield: %x", []byte(bf))
+	}
+	if err := bf.Validate(10); err != nil {
+		t.Fatal(err)
+	}
+	bf[1] |= 0x01
+	if err := bf.Validate(10); err == nil {
+		t.Fatalf("spare bit shall be rejected")
+	}
+}
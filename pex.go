@@ -0,0 +1,277 @@
+package bencode
+
+// Peer Exchange
+// http://bittorrent.org/beps/bep_0011.html
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	UtPex = "ut_pex"
+
+	// at most this many added and dropped entries per message
+	PexMaxPeers = 50
+
+	// minimum interval between two messages to one peer
+	PexInterval = time.Minute
+)
+
+type PexFlags byte
+
+const (
+	PexEncryption PexFlags = 1 << iota
+	PexSeed
+	PexUTP
+	PexHolepunch
+	PexReachable
+)
+
+func (f PexFlags) PrefersEncryption() bool { return f&PexEncryption != 0 }
+func (f PexFlags) IsSeed() bool            { return f&PexSeed != 0 }
+func (f PexFlags) SupportsUTP() bool       { return f&PexUTP != 0 }
+func (f PexFlags) SupportsHolepunch() bool { return f&PexHolepunch != 0 }
+func (f PexFlags) Reachable() bool         { return f&PexReachable != 0 }
+
+func (f PexFlags) String() string {
+	s := ""
+	for _, x := range []struct {
+		flag PexFlags
+		c    byte
+	}{{PexEncryption, 'e'}, {PexSeed, 's'}, {PexUTP, 'u'}, {PexHolepunch, 'h'}, {PexReachable, 'r'}} {
+		if f&x.flag != 0 {
+			s += string(x.c)
+		}
+	}
+	return s
+}
+
+type PexPeer struct {
+	Addr  netip.AddrPort
+	Flags PexFlags
+}
+
+type PexMessage struct {
+	Added   []PexPeer
+	Dropped []netip.AddrPort
+}
+
+// CompactPeers encodes addresses of one family as 6 or 18 bytes each.
+func CompactPeers(addrs []netip.AddrPort) []byte {
+	var buf []byte
+	for _, ap := range addrs {
+		ip := ap.Addr().Unmap()
+		if ip.Is4() {
+			a := ip.As4()
+			buf = append(buf, a[:]...)
+		} else {
+			a := ip.As16()
+			buf = append(buf, a[:]...)
+		}
+		buf = binary.BigEndian.AppendUint16(buf, ap.Port())
+	}
+	return buf
+}
+
+// ParseCompactPeers decodes 6-byte (IPv4) or 18-byte (IPv6) entries.
+func ParseCompactPeers(b []byte, ipv6 bool) ([]netip.AddrPort, error) {
+	size := 6
+	if ipv6 {
+		size = 18
+	}
+	if len(b)%size != 0 {
+		return nil, fmt.Errorf("%w: compact peers of %v byte(s)", MessageFormatError, len(b))
+	}
+	var rvs []netip.AddrPort
+	for off := 0; off < len(b); off += size {
+		ip, _ := netip.AddrFromSlice(b[off : off+size-2])
+		port := binary.BigEndian.Uint16(b[off+size-2:])
+		rvs = append(rvs, netip.AddrPortFrom(ip, port))
+	}
+	return rvs, nil
+}
+
+func (m PexMessage) Encode() []byte {
+	var added4, added6, dropped4, dropped6 []netip.AddrPort
+	var flags4, flags6 []byte
+	for _, p := range m.Added {
+		if p.Addr.Addr().Unmap().Is4() {
+			added4 = append(added4, p.Addr)
+			flags4 = append(flags4, byte(p.Flags))
+		} else {
+			added6 = append(added6, p.Addr)
+			flags6 = append(flags6, byte(p.Flags))
+		}
+	}
+	for _, ap := range m.Dropped {
+		if ap.Addr().Unmap().Is4() {
+			dropped4 = append(dropped4, ap)
+		} else {
+			dropped6 = append(dropped6, ap)
+		}
+	}
+	bin := NewBytes
+	return Encode(BNode{Map: map[string]BNode{
+		"added":    bin(CompactPeers(added4)),
+		"added.f":  bin(flags4),
+		"dropped":  bin(CompactPeers(dropped4)),
+		"added6":   bin(CompactPeers(added6)),
+		"added6.f": bin(flags6),
+		"dropped6": bin(CompactPeers(dropped6)),
+	}, Cat: BNodeMap})
+}
+
+func ParsePexMessage(payload []byte) (PexMessage, error) {
+	var m PexMessage
+	node, _, err := TryScan(payload)
+	if err != nil {
+		return m, err
+	}
+	if node.Cat != BNodeMap {
+		return m, fmt.Errorf("%w: ut_pex message is not a dictionary", MessageFormatError)
+	}
+	field := func(key string) []byte {
+		if v, ok := node.Map[key]; ok && v.IsBytes() {
+			return v.AsBinary()
+		}
+		return nil
+	}
+	for _, family := range []struct {
+		added, flags, dropped string
+		ipv6                  bool
+	}{
+		{"added", "added.f", "dropped", false},
+		{"added6", "added6.f", "dropped6", true},
+	} {
+		added, err := ParseCompactPeers(field(family.added), family.ipv6)
+		if err != nil {
+			return m, err
+		}
+		// flags are optional, a short list leaves the rest unflagged
+		flags := field(family.flags)
+		for i, ap := range added {
+			var f PexFlags
+			if i < len(flags) {
+				f = PexFlags(flags[i])
+			}
+			m.Added = append(m.Added, PexPeer{Addr: ap, Flags: f})
+		}
+		dropped, err := ParseCompactPeers(field(family.dropped), family.ipv6)
+		if err != nil {
+			return m, err
+		}
+		m.Dropped = append(m.Dropped, dropped...)
+	}
+	return m, nil
+}
+
+// PexState remembers what one peer has been told, so that only the
+// difference goes out, and no more often than Interval.
+type PexState struct {
+	Interval time.Duration
+	MaxPeers int
+
+	mu   sync.Mutex
+	sent map[netip.AddrPort]PexFlags
+	last time.Time
+	now  func() time.Time
+}
+
+func NewPexState() *PexState {
+	return &PexState{
+		Interval: PexInterval,
+		MaxPeers: PexMaxPeers,
+		sent:     make(map[netip.AddrPort]PexFlags),
+		now:      time.Now,
+	}
+}
+
+// Next computes the message to send for the current peer set. It reports
+// false when the interval has not elapsed or nothing changed. Entries beyond
+// MaxPeers are left for the next round.
+func (p *PexState) Next(current []PexPeer) (PexMessage, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var m PexMessage
+	now := p.now()
+	if !p.last.IsZero() && now.Sub(p.last) < p.Interval {
+		return m, false
+	}
+
+	cur := make(map[netip.AddrPort]PexFlags)
+	for _, peer := range current {
+		cur[peer.Addr] = peer.Flags
+	}
+	for _, peer := range current {
+		if f, ok := p.sent[peer.Addr]; (!ok || f != peer.Flags) && len(m.Added) < p.MaxPeers {
+			m.Added = append(m.Added, peer)
+		}
+	}
+	var gone []netip.AddrPort
+	for ap := range p.sent {
+		if _, ok := cur[ap]; !ok {
+			gone = append(gone, ap)
+		}
+	}
+	sort.Slice(gone, func(i, j int) bool { return gone[i].String() < gone[j].String() })
+	if len(gone) > p.MaxPeers {
+		gone = gone[:p.MaxPeers]
+	}
+	m.Dropped = gone
+
+	if len(m.Added) == 0 && len(m.Dropped) == 0 {
+		return m, false
+	}
+	for _, peer := range m.Added {
+		p.sent[peer.Addr] = peer.Flags
+	}
+	for _, ap := range m.Dropped {
+		delete(p.sent, ap)
+	}
+	p.last = now
+	return m, true
+}
+
+// key of the PexState in an ExtensionSession
+const pexStateKey = UtPex + ".state"
+
+// NewPexExtension hands every ut_pex message from a peer to onPeers, which
+// is where a connection manager learns about new peers.
+func NewPexExtension(onPeers func(s *ExtensionSession, m PexMessage)) Extension {
+	return Extension{
+		Name: UtPex,
+		Handle: func(s *ExtensionSession, payload []byte) error {
+			m, err := ParsePexMessage(payload)
+			if err != nil {
+				return err
+			}
+			if onPeers != nil {
+				onPeers(s, m)
+			}
+			return nil
+		},
+	}
+}
+
+// SendPex tells the peer about changes to our peer set, subject to the
+// rate limit of its PexState. It reports whether a message went out.
+func SendPex(s *ExtensionSession, current []PexPeer) (bool, error) {
+	if !s.Supports(UtPex) {
+		return false, nil
+	}
+	state, ok := s.Value(pexStateKey).(*PexState)
+	if !ok {
+		state = NewPexState()
+		s.SetValue(pexStateKey, state)
+	}
+	m, ok := state.Next(current)
+	if !ok {
+		return false, nil
+	}
+	return true, s.Send(UtPex, m.Encode())
+}
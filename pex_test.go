@@ -0,0 +1,118 @@
+package bencode
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestPexMessageCodec(t *testing.T) {
+	m := PexMessage{
+		Added: []PexPeer{
+			{netip.MustParseAddrPort("10.0.0.1:6881"), PexSeed | PexReachable},
+			{netip.MustParseAddrPort("[2001:db8::1]:51413"), PexUTP},
+			{netip.MustParseAddrPort("10.0.0.2:6882"), 0},
+		},
+		Dropped: []netip.AddrPort{
+			netip.MustParseAddrPort("192.168.1.9:1000"),
+			netip.MustParseAddrPort("[::1]:2000"),
+		},
+	}
+	got, err := ParsePexMessage(m.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Added) != 3 || len(got.Dropped) != 2 {
+		t.Fatalf("got %+v", got)
+	}
+	flags := map[netip.AddrPort]PexFlags{}
+	for _, p := range got.Added {
+		flags[p.Addr] = p.Flags
+	}
+	if f := flags[netip.MustParseAddrPort("10.0.0.1:6881")]; !f.IsSeed() || !f.Reachable() || f.SupportsUTP() {
+		t.Fatalf("flags: %v", f)
+	}
+	if f := flags[netip.MustParseAddrPort("[2001:db8::1]:51413")]; !f.SupportsUTP() {
+		t.Fatalf("ipv6 flags: %v", f)
+	}
+
+	if _, err := ParsePexMessage([]byte("d5:added5:12345e")); err == nil {
+		t.Fatalf("truncated compact peers shall be rejected")
+	}
+}
+
+func TestPexStateRateLimit(t *testing.T) {
+	now := time.Unix(1000, 0)
+	p := NewPexState()
+	p.MaxPeers = 2
+	p.now = func() time.Time { return now }
+
+	a := PexPeer{Addr: netip.MustParseAddrPort("10.0.0.1:1")}
+	b := PexPeer{Addr: netip.MustParseAddrPort("10.0.0.2:1")}
+	c := PexPeer{Addr: netip.MustParseAddrPort("10.0.0.3:1")}
+
+	m, ok := p.Next([]PexPeer{a, b, c})
+	if !ok || len(m.Added) != 2 {
+		t.Fatalf("first round: %+v", m)
+	}
+	if _, ok := p.Next([]PexPeer{a, b, c}); ok {
+		t.Fatalf("rate limit not applied")
+	}
+	now = now.Add(PexInterval)
+	m, ok = p.Next([]PexPeer{b, c})
+	if !ok || len(m.Added) != 1 || m.Added[0] != c || len(m.Dropped) != 1 || m.Dropped[0] != a.Addr {
+		t.Fatalf("second round: %+v", m)
+	}
+	now = now.Add(PexInterval)
+	if _, ok := p.Next([]PexPeer{b, c}); ok {
+		t.Fatalf("nothing changed, nothing to send")
+	}
+}
+
+func TestPexOverPipe(t *testing.T) {
+	x, y := net.Pipe()
+	defer x.Close()
+	defer y.Close()
+
+	discovered := make(chan PexMessage, 1)
+	regA := NewExtensionRegistry()
+	regA.Register(NewPexExtension(nil))
+	regB := NewExtensionRegistry()
+	regB.Register(NewPexExtension(func(s *ExtensionSession, m PexMessage) {
+		discovered <- m
+	}))
+	sa := NewExtensionSession(regA, NewPeerConn(x))
+	sb := NewExtensionSession(regB, NewPeerConn(y))
+
+	ready := make(chan struct{})
+	go func() {
+		m, _ := sa.Conn.ReadMessage()
+		sa.HandleMessage(m)
+		close(ready)
+	}()
+	go func() {
+		sb.SendHandshake(ExtendedHandshake{})
+		for {
+			m, err := sb.Conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			sb.HandleMessage(m)
+		}
+	}()
+	<-ready
+
+	peer := PexPeer{Addr: netip.MustParseAddrPort("10.1.2.3:4567"), Flags: PexEncryption}
+	sent, err := SendPex(sa, []PexPeer{peer})
+	if !sent || err != nil {
+		t.Fatalf("send: %v %v", sent, err)
+	}
+	m := <-discovered
+	if len(m.Added) != 1 || m.Added[0] != peer {
+		t.Fatalf("got %+v", m)
+	}
+	if sent, _ := SendPex(sa, []PexPeer{peer}); sent {
+		t.Fatalf("second message within the interval")
+	}
+}
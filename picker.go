@@ -0,0 +1,326 @@
+package bencode
+
+import (
+	"math/rand"
+	"sync"
+)
+
+type BlockRequest struct {
+	Index  uint32
+	Begin  uint32
+	Length uint32
+}
+
+func (r BlockRequest) Message() Message {
+	return RequestMessage(r.Index, r.Begin, r.Length)
+}
+
+func (r BlockRequest) CancelMessage() Message {
+	return CancelMessage(r.Index, r.Begin, r.Length)
+}
+
+type blockState struct {
+	requested int
+	received  bool
+}
+
+type partialPiece struct {
+	blocks   []blockState
+	data     []byte
+	received int
+}
+
+// PiecePicker decides which blocks to request from which peer: pieces
+// already started come first, then the rarest pieces. Once every missing
+// block is on request somewhere it switches to endgame mode and hands out
+// blocks that are already requested from other peers.
+type PiecePicker struct {
+	mu      sync.Mutex
+	sizes   []int64
+	have    Bitfield
+	wanted  Bitfield
+	avail   []int
+	partial map[int]*partialPiece
+	rnd     *rand.Rand
+
+	// complete pieces waiting for their hash check
+	verifying Bitfield
+}
+
+// NewPiecePicker takes the size of every piece and what we have already.
+func NewPiecePicker(sizes []int64, have Bitfield) *PiecePicker {
+	wanted := NewBitfield(len(sizes))
+	for i := range sizes {
+		if !have.Has(i) {
+			wanted.Set(i)
+		}
+	}
+	return &PiecePicker{
+		sizes:   sizes,
+		have:    have.Clone(),
+		wanted:  wanted,
+		avail:   make([]int, len(sizes)),
+		partial: make(map[int]*partialPiece),
+		rnd:     rand.New(rand.NewSource(rand.Int63())),
+
+		verifying: NewBitfield(len(sizes)),
+	}
+}
+
+func pieceSizes(t *Torrent) []int64 {
+	sizes := make([]int64, t.PieceCount())
+	for i := range sizes {
+		sizes[i] = t.PieceSize(i)
+	}
+	return sizes
+}
+
+// SetWanted limits the picker to the given pieces; the rest are left alone.
+func (pp *PiecePicker) SetWanted(pieces []int) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	pp.wanted = NewBitfield(len(pp.sizes))
+	for _, i := range pieces {
+		if i >= 0 && i < len(pp.sizes) {
+			pp.wanted.Set(i)
+			pp.have.Clear(i)
+		}
+	}
+}
+
+func (pp *PiecePicker) Have() Bitfield {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	return pp.have.Clone()
+}
+
+func (pp *PiecePicker) needs(i int) bool {
+	return pp.wanted.Has(i) && !pp.have.Has(i)
+}
+
+// Done reports whether every wanted piece is verified.
+func (pp *PiecePicker) Done() bool {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	for i := range pp.sizes {
+		if pp.needs(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// Interesting reports whether a peer with bf has anything we need.
+func (pp *PiecePicker) Interesting(bf Bitfield) bool {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	for i := range pp.sizes {
+		if pp.needs(i) && bf.Has(i) {
+			return true
+		}
+	}
+	return false
+}
+
+func (pp *PiecePicker) PeerHas(bf Bitfield) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	for i := range pp.avail {
+		if bf.Has(i) {
+			pp.avail[i]++
+		}
+	}
+}
+
+func (pp *PiecePicker) PeerHave(index int) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if index >= 0 && index < len(pp.avail) {
+		pp.avail[index]++
+	}
+}
+
+func (pp *PiecePicker) PeerGone(bf Bitfield) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	for i := range pp.avail {
+		if bf.Has(i) && pp.avail[i] > 0 {
+			pp.avail[i]--
+		}
+	}
+}
+
+func (pp *PiecePicker) start(i int) *partialPiece {
+	size := pp.sizes[i]
+	n := int((size + BlockSize - 1) / BlockSize)
+	p := &partialPiece{
+		blocks: make([]blockState, n),
+		data:   make([]byte, size),
+	}
+	pp.partial[i] = p
+	return p
+}
+
+func (pp *PiecePicker) blockRequest(i, b int) BlockRequest {
+	begin := int64(b) * BlockSize
+	length := pp.sizes[i] - begin
+	if length > BlockSize {
+		length = BlockSize
+	}
+	return BlockRequest{Index: uint32(i), Begin: uint32(begin), Length: uint32(length)}
+}
+
+// Pick returns up to max blocks to request from a peer having bf.
+// inflight holds what is already requested from that peer.
+func (pp *PiecePicker) Pick(bf Bitfield, max int, inflight map[BlockRequest]bool) []BlockRequest {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	var rvs []BlockRequest
+	if max <= 0 {
+		return rvs
+	}
+
+	takeFrom := func(i int, p *partialPiece, endgame bool) bool {
+		for b := range p.blocks {
+			st := &p.blocks[b]
+			if st.received || (st.requested > 0 && !endgame) {
+				continue
+			}
+			req := pp.blockRequest(i, b)
+			if inflight[req] {
+				continue
+			}
+			st.requested++
+			rvs = append(rvs, req)
+			if len(rvs) >= max {
+				return true
+			}
+		}
+		return false
+	}
+
+	// finish what is started
+	for i, p := range pp.partial {
+		if bf.Has(i) && takeFrom(i, p, false) {
+			return rvs
+		}
+	}
+
+	// rarest first, ties broken at random
+	for len(rvs) < max {
+		best, bestAvail, ties := -1, 0, 0
+		for i := range pp.sizes {
+			if !pp.needs(i) || !bf.Has(i) || pp.verifying.Has(i) {
+				continue
+			}
+			if _, ok := pp.partial[i]; ok {
+				continue
+			}
+			switch {
+			case best < 0 || pp.avail[i] < bestAvail:
+				best, bestAvail, ties = i, pp.avail[i], 1
+			case pp.avail[i] == bestAvail:
+				ties++
+				if pp.rnd.Intn(ties) == 0 {
+					best = i
+				}
+			}
+		}
+		if best < 0 {
+			break
+		}
+		if takeFrom(best, pp.start(best), false) {
+			return rvs
+		}
+	}
+
+	if len(rvs) == 0 && pp.endgame() {
+		for i, p := range pp.partial {
+			if bf.Has(i) && takeFrom(i, p, true) {
+				return rvs
+			}
+		}
+	}
+	return rvs
+}
+
+// endgame: nothing is left that is not already on request
+func (pp *PiecePicker) endgame() bool {
+	for i := range pp.sizes {
+		if !pp.needs(i) || pp.verifying.Has(i) {
+			continue
+		}
+		p, ok := pp.partial[i]
+		if !ok {
+			return false
+		}
+		for _, st := range p.blocks {
+			if !st.received && st.requested == 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (pp *PiecePicker) Endgame() bool {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	return pp.endgame()
+}
+
+// Cancel returns a request that will not be answered, e.g. after a choke.
+func (pp *PiecePicker) Cancel(req BlockRequest) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if p, ok := pp.partial[int(req.Index)]; ok {
+		b := int(req.Begin / BlockSize)
+		if b < len(p.blocks) && p.blocks[b].requested > 0 {
+			p.blocks[b].requested--
+		}
+	}
+}
+
+// Received stores a block. When it completes its piece, the piece data is
+// returned for verification and the piece leaves the picker until
+// PieceVerified or PieceFailed is called.
+func (pp *PiecePicker) Received(index, begin int, block []byte) ([]byte, bool) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	p, ok := pp.partial[index]
+	if !ok || begin%BlockSize != 0 {
+		return nil, false
+	}
+	b := begin / BlockSize
+	if b >= len(p.blocks) || int64(len(block)) != int64(pp.blockRequest(index, b).Length) {
+		return nil, false
+	}
+	if p.blocks[b].received {
+		return nil, false
+	}
+	copy(p.data[begin:], block)
+	p.blocks[b].received = true
+	p.received++
+	if p.received < len(p.blocks) {
+		return nil, false
+	}
+	delete(pp.partial, index)
+	pp.verifying.Set(index)
+	return p.data, true
+}
+
+func (pp *PiecePicker) PieceVerified(index int) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	pp.verifying.Clear(index)
+	pp.have.Set(index)
+}
+
+// PieceFailed puts a piece whose hash did not match back into play.
+func (pp *PiecePicker) PieceFailed(index int) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	delete(pp.partial, index)
+	pp.verifying.Clear(index)
+	pp.have.Clear(index)
+}
@@ -0,0 +1,344 @@
+package bencode
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// PrintFormat selects how a Printer lays out a node.
+type PrintFormat int
+
+const (
+	// one value per line, nested values indented under their key
+	PrintTree PrintFormat = iota
+	// JSON-like; binary strings become strings rendered per Binary
+	PrintJSON
+	// the canonical encoding in hex, one token per line with its meaning
+	PrintHexdump
+)
+
+// BinaryFormat selects how byte strings that are not text are shown.
+type BinaryFormat int
+
+const (
+	BinarySummary BinaryFormat = iota // just the length
+	BinaryHex
+	BinaryBase64
+)
+
+// Printer renders nodes for people. The zero value writes a tree to
+// stdout with keys in map order; NewPrinter sets friendlier defaults.
+type Printer struct {
+	W      io.Writer // os.Stdout when nil
+	Format PrintFormat
+	Indent string // two spaces when empty
+
+	SortKeys bool
+
+	// strings longer than this many bytes are cut; 0 keeps them whole
+	MaxString int
+
+	Binary BinaryFormat
+
+	// dictionary entries whose key matches one of these path.Match
+	// patterns are left out, at any depth
+	Exclude []string
+}
+
+func NewPrinter(w io.Writer) *Printer {
+	return &Printer{W: w, Indent: "  ", SortKeys: true}
+}
+
+// Print writes node in the selected format, ending with a newline.
+func (p *Printer) Print(node BNode) error {
+	w := &printWriter{w: p.W}
+	if w.w == nil {
+		w.w = os.Stdout
+	}
+	switch p.Format {
+	case PrintTree:
+		p.tree(w, node, 0)
+	case PrintJSON:
+		p.json(w, node, 0)
+		w.printf("\n")
+	case PrintHexdump:
+		var off int64
+		p.hexdump(w, node, 0, &off)
+	default:
+		return fmt.Errorf("%w: print format %v", TypeError, p.Format)
+	}
+	return w.err
+}
+
+// printWriter keeps the first write error so the printers need not
+// check every call.
+type printWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *printWriter) printf(format string, args ...interface{}) {
+	if w.err == nil {
+		_, w.err = fmt.Fprintf(w.w, format, args...)
+	}
+}
+
+func (p *Printer) indent(depth int) string {
+	if p.Indent == "" {
+		return strings.Repeat("  ", depth)
+	}
+	return strings.Repeat(p.Indent, depth)
+}
+
+// keys returns the keys of m to print, in order.
+func (p *Printer) keys(m map[string]BNode) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		if !p.excluded(k) {
+			keys = append(keys, k)
+		}
+	}
+	if p.SortKeys {
+		sort.Strings(keys)
+	}
+	return keys
+}
+
+func (p *Printer) excluded(key string) bool {
+	for _, pat := range p.Exclude {
+		if ok, _ := path.Match(pat, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// text cuts s to MaxString bytes, on a rune boundary.
+func (p *Printer) text(s string) (string, bool) {
+	if p.MaxString <= 0 || len(s) <= p.MaxString {
+		return s, false
+	}
+	cut := p.MaxString
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut], true
+}
+
+// binary renders a byte string that is not text.
+func (p *Printer) binary(b []byte) string {
+	if p.Binary == BinarySummary {
+		return fmt.Sprintf("<%v bytes>", len(b))
+	}
+	whole := len(b)
+	if p.MaxString > 0 && len(b) > p.MaxString {
+		b = b[:p.MaxString]
+	}
+	var s string
+	if p.Binary == BinaryBase64 {
+		s = base64.StdEncoding.EncodeToString(b)
+	} else {
+		s = hex.EncodeToString(b)
+	}
+	if len(b) < whole {
+		s += fmt.Sprintf("... (%v bytes)", whole)
+	}
+	return s
+}
+
+// str renders a byte string node for the tree and JSON formats; quote
+// asks for a quoted string.
+func (p *Printer) str(node BNode, quote func(string) string) string {
+	if !node.IsText() {
+		return quote(p.binary(node.AsBinary()))
+	}
+	s := node.AsString()
+	t, cut := p.text(s)
+	if cut {
+		// the marker goes inside the quotes, so JSON stays valid
+		t += fmt.Sprintf("... (%v bytes)", len(s))
+	}
+	return quote(t)
+}
+
+// key renders a dictionary key; keys are never cut.
+func (p *Printer) key(k string, quote func(string) string) string {
+	if n := NewString(k); !n.IsText() {
+		return quote(hex.EncodeToString([]byte(k)))
+	}
+	return quote(k)
+}
+
+func treeQuote(s string) string {
+	if strings.ContainsAny(s, "\t\n\r") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func jsonQuote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func (p *Printer) tree(w *printWriter, node BNode, depth int) {
+	switch {
+	case node.IsBytes():
+		w.printf("%v\n", p.str(node, treeQuote))
+	case node.Cat == BNodeInteger:
+		w.printf("%v\n", *node.Int)
+	case node.Cat == BNodeList:
+		if len(node.List) == 0 {
+			w.printf("[]\n")
+			return
+		}
+		w.printf("[\n")
+		for _, el := range node.List {
+			w.printf("%v", p.indent(depth+1))
+			p.tree(w, el, depth+1)
+		}
+		w.printf("%v]\n", p.indent(depth))
+	case node.Cat == BNodeMap:
+		keys := p.keys(node.Map)
+		if len(keys) == 0 {
+			w.printf("{}\n")
+			return
+		}
+		w.printf("{\n")
+		for _, k := range keys {
+			w.printf("%v%v: ", p.indent(depth+1), p.key(k, treeQuote))
+			p.tree(w, node.Map[k], depth+1)
+		}
+		w.printf("%v}\n", p.indent(depth))
+	default:
+		w.printf("<invalid>\n")
+	}
+}
+
+func (p *Printer) json(w *printWriter, node BNode, depth int) {
+	switch {
+	case node.IsBytes():
+		w.printf("%v", p.str(node, jsonQuote))
+	case node.Cat == BNodeInteger:
+		w.printf("%v", *node.Int)
+	case node.Cat == BNodeList:
+		if len(node.List) == 0 {
+			w.printf("[]")
+			return
+		}
+		w.printf("[\n")
+		for i, el := range node.List {
+			w.printf("%v", p.indent(depth+1))
+			p.json(w, el, depth+1)
+			if i < len(node.List)-1 {
+				w.printf(",")
+			}
+			w.printf("\n")
+		}
+		w.printf("%v]", p.indent(depth))
+	case node.Cat == BNodeMap:
+		keys := p.keys(node.Map)
+		if len(keys) == 0 {
+			w.printf("{}")
+			return
+		}
+		w.printf("{\n")
+		for i, k := range keys {
+			w.printf("%v%v: ", p.indent(depth+1), p.key(k, jsonQuote))
+			p.json(w, node.Map[k], depth+1)
+			if i < len(keys)-1 {
+				w.printf(",")
+			}
+			w.printf("\n")
+		}
+		w.printf("%v}", p.indent(depth))
+	default:
+		w.printf("null")
+	}
+}
+
+const hexdumpWidth = 16
+
+// hexdump writes the canonical encoding of node from *off on. Excluded
+// keys are skipped, and so are the offsets of their bytes.
+func (p *Printer) hexdump(w *printWriter, node BNode, depth int, off *int64) {
+	switch {
+	case node.IsBytes():
+		what := "bytes " + p.binary(node.AsBinary())
+		if node.IsText() {
+			what = "string " + p.str(node, strconv.Quote)
+		}
+		p.hexString(w, node.AsBinary(), depth, off, what)
+	case node.Cat == BNodeInteger:
+		p.hexLine(w, []byte("i"+strconv.FormatInt(*node.Int, 10)+"e"), depth, off, fmt.Sprintf("int %v", *node.Int))
+	case node.Cat == BNodeList:
+		p.hexLine(w, []byte("l"), depth, off, fmt.Sprintf("list, %v items", len(node.List)))
+		for _, el := range node.List {
+			p.hexdump(w, el, depth+1, off)
+		}
+		p.hexLine(w, []byte("e"), depth, off, "end")
+	case node.Cat == BNodeMap:
+		keys := make([]string, 0, len(node.Map))
+		for k := range node.Map {
+			keys = append(keys, k)
+		}
+		// offsets only mean something in the canonical order
+		sort.Strings(keys)
+		p.hexLine(w, []byte("d"), depth, off, fmt.Sprintf("dict, %v keys", len(keys)))
+		for _, k := range keys {
+			if p.excluded(k) {
+				*off += int64(len(Encode(NewString(k))) + len(Encode(node.Map[k])))
+				continue
+			}
+			p.hexString(w, []byte(k), depth+1, off, "key "+strconv.Quote(k))
+			p.hexdump(w, node.Map[k], depth+2, off)
+		}
+		p.hexLine(w, []byte("e"), depth, off, "end")
+	}
+}
+
+// hexString dumps a length-prefixed string, cut after MaxString bytes.
+func (p *Printer) hexString(w *printWriter, b []byte, depth int, off *int64, what string) {
+	head := strconv.Itoa(len(b)) + ":"
+	shown := b
+	if p.MaxString > 0 && len(shown) > p.MaxString {
+		shown = shown[:p.MaxString]
+	}
+	p.hexLine(w, append([]byte(head), shown...), depth, off, what)
+	if rest := len(b) - len(shown); rest > 0 {
+		w.printf("%08x  %-*s  %v... %v more bytes\n", *off, hexdumpWidth*3-1, "", p.indent(depth), rest)
+		*off += int64(rest)
+	}
+}
+
+// hexLine dumps b over as many lines as it takes; the first line carries
+// the annotation.
+func (p *Printer) hexLine(w *printWriter, b []byte, depth int, off *int64, what string) {
+	for first := true; first || len(b) > 0; first = false {
+		n := len(b)
+		if n > hexdumpWidth {
+			n = hexdumpWidth
+		}
+		cols := make([]string, n)
+		for i := range cols {
+			cols[i] = hex.EncodeToString(b[i : i+1])
+		}
+		note := ""
+		if first {
+			note = p.indent(depth) + what
+		}
+		line := fmt.Sprintf("%08x  %-*s  %v", *off, hexdumpWidth*3-1, strings.Join(cols, " "), note)
+		w.printf("%v\n", strings.TrimRight(line, " "))
+		*off += int64(n)
+		b = b[n:]
+	}
+}
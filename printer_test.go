@@ -0,0 +1,67 @@
+package bencode
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPrinter(t *testing.T) {
+	node := MustScanString("d4:name4:spam6:pieces20:\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x137:comment10:0123456789" +
+		"4:listli1ei2ee5:nodeslee")
+
+	var buf bytes.Buffer
+	p := NewPrinter(&buf)
+	p.Exclude = []string{"no*"}
+	p.MaxString = 4
+	if err := p.Print(node); err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  comment: 0123... (10 bytes)
+  list: [
+    1
+    2
+  ]
+  name: spam
+  pieces: <20 bytes>
+}
+`
+	if buf.String() != want {
+		t.Errorf("tree:\n%v", buf.String())
+	}
+
+	buf.Reset()
+	p.Format, p.Binary, p.Exclude = PrintJSON, BinaryHex, nil
+	p.Print(node)
+	if !json.Valid(buf.Bytes()) || !strings.Contains(buf.String(), `"comment": "0123... (10 bytes)",`) {
+		t.Errorf("cut json:\n%v", buf.String())
+	}
+
+	buf.Reset()
+	p.MaxString = 0
+	p.Print(node)
+	for _, s := range []string{`"name": "spam",`, `"pieces": "000102030405060708090a0b0c0d0e0f10111213"`, `"nodes": []`} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("json lacks %v:\n%v", s, buf.String())
+		}
+	}
+
+	buf.Reset()
+	p = NewPrinter(&buf)
+	p.Format = PrintHexdump
+	p.Print(MustScanString("d1:ai42e1:bl3:fooee"))
+	want = `00000000  64                                               dict, 2 keys
+00000001  31 3a 61                                           key "a"
+00000004  69 34 32 65                                          int 42
+00000008  31 3a 62                                           key "b"
+0000000b  6c                                                   list, 1 items
+0000000c  33 3a 66 6f 6f                                         string "foo"
+00000011  65                                                   end
+00000012  65                                               end
+`
+	if buf.String() != want {
+		t.Errorf("hexdump:\n%v", buf.String())
+	}
+}
@@ -0,0 +1,224 @@
+package bencode
+
+// Path queries over node trees:
+//
+//	info.name              a dictionary key
+//	info.files[3].path[-1] list items, counting from the end when negative
+//	announce-list[*][0]    every item of a list, or every value of a dictionary
+//	info["piece length"]   a key holding '.', '[' or other odd characters
+//
+// A query returns every node it reaches, in document order for lists and
+// key order for dictionaries.
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	QuerySyntaxError = errors.New("bad query")
+	QueryError       = errors.New("query failed")
+)
+
+// SegmentError names the segment of a query that could not be followed.
+type SegmentError struct {
+	Segment string // as written in the query
+	At      string // the node it was applied to, "" for the root
+	Reason  string
+}
+
+func (e *SegmentError) Error() string {
+	at := e.At
+	if at == "" {
+		at = "root"
+	}
+	return fmt.Sprintf("%v: segment %v at %v: %v", QueryError, e.Segment, at, e.Reason)
+}
+
+func (e *SegmentError) Unwrap() error {
+	return QueryError
+}
+
+type querySeg struct {
+	text  string
+	key   string
+	index int
+	list  bool // index rather than key
+	wild  bool
+}
+
+func parseQuery(expr string) ([]querySeg, error) {
+	var segs []querySeg
+	bad := func(pos int, why string) error {
+		return fmt.Errorf("%w: %q at %v: %v", QuerySyntaxError, expr, pos, why)
+	}
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; c {
+		case '[':
+			end := strings.IndexByte(expr[i:], ']')
+			if end < 0 {
+				return nil, bad(i, "unclosed [")
+			}
+			in := expr[i+1 : i+end]
+			seg := querySeg{text: expr[i : i+end+1]}
+			switch {
+			case in == "*":
+				seg.wild = true
+			case strings.HasPrefix(in, `"`):
+				// the key may hold a ']' itself
+				q, err := strconv.QuotedPrefix(expr[i+1:])
+				if err != nil || !strings.HasPrefix(expr[i+1+len(q):], "]") {
+					return nil, bad(i, "bad quoted key")
+				}
+				seg.text = expr[i : i+len(q)+2]
+				seg.key, _ = strconv.Unquote(q)
+				end = len(q) + 1
+			default:
+				n, err := strconv.Atoi(in)
+				if err != nil {
+					return nil, bad(i, fmt.Sprintf("index %q is not a number", in))
+				}
+				seg.index, seg.list = n, true
+			}
+			segs = append(segs, seg)
+			i += end + 1
+		default:
+			if len(segs) > 0 {
+				if c != '.' {
+					return nil, bad(i, "want . or [")
+				}
+				i++
+			}
+			end := strings.IndexAny(expr[i:], ".[")
+			if end < 0 {
+				end = len(expr) - i
+			}
+			if end == 0 {
+				return nil, bad(i, "empty key")
+			}
+			key := expr[i : i+end]
+			segs = append(segs, querySeg{text: key, key: key, wild: key == "*"})
+			i += end
+		}
+	}
+	return segs, nil
+}
+
+type queryHit struct {
+	node BNode
+	at   string
+}
+
+// Query returns the nodes expr leads to from b. An empty expression
+// is b itself.
+func (b BNode) Query(expr string) ([]BNode, error) {
+	segs, err := parseQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	hits := []queryHit{{node: b}}
+	for _, seg := range segs {
+		var next []queryHit
+		for _, h := range hits {
+			more, err := seg.follow(h)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, more...)
+		}
+		hits = next
+	}
+	rvs := make([]BNode, len(hits))
+	for i, h := range hits {
+		rvs[i] = h.node
+	}
+	return rvs, nil
+}
+
+// Get is Query for expressions that reach exactly one node.
+func (b BNode) Get(expr string) (BNode, error) {
+	rvs, err := b.Query(expr)
+	if err != nil {
+		return BNode{}, err
+	}
+	if len(rvs) != 1 {
+		return BNode{}, fmt.Errorf("%w: %q matches %v nodes", QueryError, expr, len(rvs))
+	}
+	return rvs[0], nil
+}
+
+func (seg querySeg) follow(h queryHit) ([]queryHit, error) {
+	fail := func(format string, args ...interface{}) error {
+		return &SegmentError{Segment: seg.text, At: h.at, Reason: fmt.Sprintf(format, args...)}
+	}
+	switch {
+	case seg.wild && h.node.Cat == BNodeList:
+		rvs := make([]queryHit, len(h.node.List))
+		for i, el := range h.node.List {
+			rvs[i] = queryHit{el, fmt.Sprintf("%v[%v]", h.at, i)}
+		}
+		return rvs, nil
+	case seg.wild && h.node.Cat == BNodeMap:
+		keys := make([]string, 0, len(h.node.Map))
+		for k := range h.node.Map {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		rvs := make([]queryHit, len(keys))
+		for i, k := range keys {
+			rvs[i] = queryHit{h.node.Map[k], keyPath(h.at, k)}
+		}
+		return rvs, nil
+	case seg.wild:
+		return nil, fail("%v has no items", catName(h.node.Cat))
+	case seg.list:
+		if h.node.Cat != BNodeList {
+			return nil, fail("not a list but %v", catName(h.node.Cat))
+		}
+		i := seg.index
+		if i < 0 {
+			i += len(h.node.List)
+		}
+		if i < 0 || i >= len(h.node.List) {
+			return nil, fail("index %v out of range, %v items", seg.index, len(h.node.List))
+		}
+		return []queryHit{{h.node.List[i], fmt.Sprintf("%v[%v]", h.at, i)}}, nil
+	default:
+		if h.node.Cat != BNodeMap {
+			return nil, fail("not a dictionary but %v", catName(h.node.Cat))
+		}
+		v, ok := h.node.Map[seg.key]
+		if !ok {
+			return nil, fail("no key %q", seg.key)
+		}
+		return []queryHit{{v, keyPath(h.at, seg.key)}}, nil
+	}
+}
+
+// keyPath appends key to a query path, quoting it when needed.
+func keyPath(at, key string) string {
+	if key == "" || key == "*" || strings.ContainsAny(key, ".[]\"") {
+		return at + "[" + strconv.Quote(key) + "]"
+	}
+	if at == "" {
+		return key
+	}
+	return at + "." + key
+}
+
+func catName(cat int) string {
+	switch cat {
+	case BNodeMap:
+		return "a dictionary"
+	case BNodeString:
+		return "a string"
+	case BNodeList:
+		return "a list"
+	case BNodeInteger:
+		return "an integer"
+	}
+	return "invalid"
+}
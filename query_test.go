@@ -0,0 +1,62 @@
+package bencode
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	node := MustScanString("d8:announce3:t/113:announce-listll3:t/13:t/2el3:t/3ee" +
+		"4:infod5:filesld6:lengthi1e4:pathl1:a1:beed6:lengthi2e4:pathl1:ceee12:piece lengthi16384eee")
+
+	strs := func(ns []BNode) []string {
+		var rvs []string
+		for _, n := range ns {
+			rvs = append(rvs, n.AsString())
+		}
+		return rvs
+	}
+	for expr, want := range map[string][]string{
+		"announce":               {"t/1"},
+		"announce-list[*][0]":    {"t/1", "t/3"},
+		"info.files[0].path[-1]": {"b"},
+		"info.files[*].path[0]":  {"a", "c"},
+		"announce-list[-1][-1]":  {"t/3"},
+	} {
+		got, err := node.Query(expr)
+		if err != nil {
+			t.Fatalf("%v: %v", expr, err)
+		}
+		if !reflect.DeepEqual(strs(got), want) {
+			t.Errorf("%v: %v", expr, strs(got))
+		}
+	}
+	if n, err := node.Get(`info["piece length"]`); err != nil || n.AsInt() != 16384 {
+		t.Errorf("quoted key: %v", err)
+	}
+	if all, _ := node.Query("info.*"); len(all) != 2 {
+		t.Errorf("dictionary wildcard: %v", len(all))
+	}
+	if _, err := node.Get("announce-list[*]"); !errors.Is(err, QueryError) {
+		t.Errorf("Get of many: %v", err)
+	}
+
+	for expr, want := range map[string]SegmentError{
+		"info.files[5]":           {"[5]", "info.files", "index 5 out of range, 2 items"},
+		"info.files[1].name":      {"name", "info.files[1]", `no key "name"`},
+		"announce[0]":             {"[0]", "announce", "not a list but a string"},
+		"info.files[-1].length.x": {"x", "info.files[1].length", "not a dictionary but an integer"},
+	} {
+		_, err := node.Query(expr)
+		var se *SegmentError
+		if !errors.As(err, &se) || *se != want || !errors.Is(err, QueryError) {
+			t.Errorf("%v: %v", expr, err)
+		}
+	}
+	for _, expr := range []string{"a..b", ".a", "a.", "a[x]", "a[0", `a["b]`, "a[0]b"} {
+		if _, err := node.Query(expr); !errors.Is(err, QuerySyntaxError) {
+			t.Errorf("%v: %v", expr, err)
+		}
+	}
+}
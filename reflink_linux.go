@@ -0,0 +1,32 @@
+package bencode
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// FICLONE from linux/fs.h
+const ficlone = 0x40049409
+
+// reflinkFile shares the extents of src with a new file dst, on file
+// systems that can (btrfs, xfs).
+func reflinkFile(src, dst string) error {
+	fin, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer fin.Close()
+	fout, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer fout.Close()
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fout.Fd(), ficlone, fin.Fd())
+	if errno != 0 {
+		fout.Close()
+		os.Remove(dst)
+		return fmt.Errorf("%w: %v", ReflinkUnsupportedError, errno)
+	}
+	return nil
+}
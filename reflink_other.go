@@ -0,0 +1,7 @@
+//go:build !linux
+
+package bencode
+
+func reflinkFile(src, dst string) error {
+	return ReflinkUnsupportedError
+}
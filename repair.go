@@ -0,0 +1,116 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// RepairSource supplies good data for pieces that failed verification.
+// FetchPieces writes what it can into s; the caller re-verifies afterwards.
+type RepairSource interface {
+	FetchPieces(s *Storage, pieces []int) error
+}
+
+// PeerSource fetches pieces from peers through the download engine.
+type PeerSource struct {
+	Peers   []string
+	Dial    DialFunc
+	Timeout time.Duration
+}
+
+func (ps PeerSource) FetchPieces(s *Storage, pieces []int) error {
+	t := s.Torrent()
+	d := NewDownloader(t, s.Dir, ps.Peers)
+	d.Storage = s
+	if ps.Dial != nil {
+		d.Dial = ps.Dial
+	}
+	if ps.Timeout > 0 {
+		d.Timeout = ps.Timeout
+	}
+	// everything outside the failed set is taken as good
+	have := NewBitfield(t.PieceCount())
+	for i := 0; i < t.PieceCount(); i++ {
+		have.Set(i)
+	}
+	d.Resume = have
+	d.Wanted = pieces
+	return d.Run()
+}
+
+// LocalSource copies pieces from another copy of the same torrent laid out
+// under Dir. Only pieces that verify there are copied.
+type LocalSource struct {
+	Dir string
+}
+
+func (ls LocalSource) FetchPieces(s *Storage, pieces []int) error {
+	t := s.Torrent()
+	other := NewStorage(t, ls.Dir)
+	defer other.Close()
+	missing := 0
+	for _, i := range pieces {
+		data, err := other.ReadPiece(i)
+		if err != nil || !bytes.Equal(calcSha1Hash(data), t.PieceHash(i)) {
+			missing++
+			continue
+		}
+		if err := s.WritePiece(i, data); err != nil {
+			return err
+		}
+	}
+	if missing > 0 {
+		return fmt.Errorf("%w: %v piece(s) not available in %v", IncompleteError, missing, ls.Dir)
+	}
+	return nil
+}
+
+type RepairReport struct {
+	Requested []int
+	Repaired  []int
+	Failed    []int
+}
+
+// Repair re-fetches the failed pieces of the data in s, trying the sources
+// in order until every piece verifies. Pieces are written back in place,
+// across file boundaries where they span several files.
+func Repair(s *Storage, failed []int, sources ...RepairSource) (RepairReport, error) {
+	report := RepairReport{Requested: append([]int(nil), failed...)}
+	sort.Ints(report.Requested)
+	remaining := report.Requested
+
+	for _, src := range sources {
+		if len(remaining) == 0 {
+			break
+		}
+		if err := src.FetchPieces(s, remaining); err != nil {
+			log.Printf("repair from %T: %v", src, err)
+		}
+		var still []int
+		for _, i := range remaining {
+			if ok, _ := s.VerifyPiece(i); ok {
+				report.Repaired = append(report.Repaired, i)
+			} else {
+				still = append(still, i)
+			}
+		}
+		remaining = still
+	}
+	sort.Ints(report.Repaired)
+	report.Failed = remaining
+	if len(remaining) > 0 {
+		return report, fmt.Errorf("%w: %v piece(s) not repaired", IncompleteError, len(remaining))
+	}
+	return report, nil
+}
+
+// Repair verifies the data under dir and repairs what fails.
+func (t *Torrent) Repair(dir string, sources ...RepairSource) (RepairReport, error) {
+	s := NewStorage(t, dir)
+	defer s.Close()
+	failed := s.Verify().Missing(t.PieceCount())
+	return Repair(s, failed, sources...)
+}
@@ -0,0 +1,71 @@
+package bencode
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func corruptFile(t *testing.T, p string, off int64, n int) {
+	f, err := os.OpenFile(p, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt(make([]byte, n), off); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	files := []testFile{
+		{[]string{"a.bin"}, patternData(50000, 7)},
+		{[]string{"b.bin"}, patternData(50000, 8)},
+	}
+	raw, _ := buildTestTorrent("fix", 16*1024, files)
+	tr, _ := ParseTorrent(raw)
+	goodDir := t.TempDir()
+	writeTestFiles(t, goodDir, "fix", files)
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "fix", files)
+	// piece 3 spans the end of a.bin and the start of b.bin
+	corruptFile(t, filepath.Join(dir, "fix", "a.bin"), 49990, 10)
+	corruptFile(t, filepath.Join(dir, "fix", "b.bin"), 0, 10)
+	corruptFile(t, filepath.Join(dir, "fix", "b.bin"), 40000, 1)
+
+	s := NewStorage(tr, dir)
+	failed := s.Verify().Missing(tr.PieceCount())
+	s.Close()
+	if len(failed) != 2 || failed[0] != 3 || failed[1] != 5 {
+		t.Fatalf("failed pieces: %v", failed)
+	}
+
+	// nothing to take from an empty copy, then the good one
+	report, err := tr.Repair(dir, LocalSource{Dir: t.TempDir()}, LocalSource{Dir: goodDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Repaired) != 2 || len(report.Failed) != 0 {
+		t.Fatalf("report: %+v", report)
+	}
+	checkTestFiles(t, dir, "fix", files)
+
+	// again, this time from a peer
+	corruptFile(t, filepath.Join(dir, "fix", "b.bin"), 40000, 1)
+	addr := startTestSeeder(t, tr, goodDir)
+	report, err = tr.Repair(dir, PeerSource{Peers: []string{addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Repaired) != 1 || report.Repaired[0] != 5 {
+		t.Fatalf("report: %+v", report)
+	}
+	checkTestFiles(t, dir, "fix", files)
+
+	// no source at all
+	corruptFile(t, filepath.Join(dir, "fix", "a.bin"), 0, 1)
+	if report, err := tr.Repair(dir); err == nil || len(report.Failed) != 1 {
+		t.Fatalf("expect an error, got %+v", report)
+	}
+}
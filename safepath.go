@@ -0,0 +1,167 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+var (
+	UnsafePathError = errors.New("unsafe path in torrent")
+)
+
+// PathError names the file entry whose path was refused.
+type PathError struct {
+	Index     int // file index, -1 for the torrent name
+	Component string
+	Reason    string
+}
+
+func (e *PathError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("%v: name %q: %v", UnsafePathError, e.Component, e.Reason)
+	}
+	return fmt.Sprintf("%v: file %v: component %q: %v", UnsafePathError, e.Index, e.Component, e.Reason)
+}
+
+func (e *PathError) Unwrap() error {
+	return UnsafePathError
+}
+
+// PathPolicy decides what happens to unsafe path components.
+type PathPolicy int
+
+const (
+	PathReject PathPolicy = iota
+	PathEscape
+)
+
+// device names Windows reserves in every directory, with any extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+func isReservedName(c string) bool {
+	if i := strings.IndexByte(c, '.'); i >= 0 {
+		c = c[:i]
+	}
+	return reservedNames[strings.ToUpper(strings.TrimRight(c, " "))]
+}
+
+func isDrive(c string) bool {
+	return len(c) >= 2 && c[1] == ':' && (c[0]|0x20 >= 'a' && c[0]|0x20 <= 'z')
+}
+
+// unsafeReason tells why a single path component cannot be used as is,
+// or returns "" when it can.
+func unsafeReason(c string) string {
+	switch {
+	case c == "":
+		return "empty"
+	case c == "." || c == "..":
+		return "relative"
+	case strings.ContainsAny(c, `/\`):
+		return "separator"
+	case isDrive(c):
+		return "drive"
+	case strings.ContainsRune(c, 0):
+		return "nul byte"
+	case strings.IndexFunc(c, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0:
+		return "control character"
+	case isReservedName(c):
+		return "reserved name"
+	case runtime.GOOS == "windows" && strings.TrimRight(c, ". ") != c:
+		return "trailing dot or space"
+	}
+	return ""
+}
+
+// escapeComponent turns c into a harmless name; safe ones come back as is.
+func escapeComponent(c string) string {
+	switch c {
+	case "":
+		return "_"
+	case ".":
+		return "_"
+	case "..":
+		return "__"
+	}
+	c = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < 0x20 || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, c)
+	if isDrive(c) {
+		c = c[:1] + "_" + c[2:]
+	}
+	if isReservedName(c) {
+		c = "_" + c
+	}
+	if runtime.GOOS == "windows" {
+		if trimmed := strings.TrimRight(c, ". "); trimmed != c {
+			c = trimmed + strings.Repeat("_", len(c)-len(trimmed))
+		}
+	}
+	return c
+}
+
+// sanitizePath checks the components of entry index, rejecting or
+// escaping the dangerous ones. The result never leaves the directory it
+// is joined to.
+func sanitizePath(index int, comps []string, policy PathPolicy) ([]string, error) {
+	if len(comps) == 0 {
+		return nil, &PathError{Index: index, Reason: "no path"}
+	}
+	rvs := make([]string, len(comps))
+	for i, c := range comps {
+		reason := unsafeReason(c)
+		switch {
+		case reason == "":
+			rvs[i] = c
+		case policy == PathEscape:
+			rvs[i] = escapeComponent(c)
+		default:
+			return nil, &PathError{Index: index, Component: c, Reason: reason}
+		}
+	}
+	return rvs, nil
+}
+
+// SetPathPolicy picks between refusing and escaping unsafe file names.
+// Files are refused by default.
+func (t *Torrent) SetPathPolicy(policy PathPolicy) {
+	t.pathPolicy = policy
+}
+
+// SafePath returns the torrent name followed by the path of fe, fit to be
+// joined to a download directory.
+func (t *Torrent) SafePath(fe FileEntry) ([]string, error) {
+	name, err := sanitizePath(-1, []string{t.Name()}, t.pathPolicy)
+	if err != nil {
+		return nil, err
+	}
+	if !t.IsMultiFile() {
+		return name, nil
+	}
+	ps, err := sanitizePath(fe.Index, fe.Path, t.pathPolicy)
+	if err != nil {
+		return nil, err
+	}
+	return append(name, ps...), nil
+}
+
+// CheckPaths reports the first file whose path is unsafe.
+func (t *Torrent) CheckPaths() error {
+	for _, fe := range t.Files() {
+		if _, err := t.SafePath(fe); err != nil {
+			return err
+		}
+	}
+	return nil
+}
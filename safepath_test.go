@@ -0,0 +1,71 @@
+package bencode
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitizePath(t *testing.T) {
+	for _, c := range []string{"", ".", "..", "a/b", `a\b`, "C:", "x\x00y", "tab\there", "CON", "nul.txt", "Com1.tar.gz"} {
+		if unsafeReason(c) == "" {
+			t.Errorf("%q passed", c)
+		}
+		esc := escapeComponent(c)
+		if unsafeReason(esc) != "" {
+			t.Errorf("%q escaped to unsafe %q", c, esc)
+		}
+	}
+	for _, c := range []string{"a.mkv", "..hidden", "Movie: Part 1", "CONSOLE.txt", "日本語"} {
+		if r := unsafeReason(c); r != "" {
+			t.Errorf("%q refused: %v", c, r)
+		}
+	}
+}
+
+func TestUnsafeTorrentPaths(t *testing.T) {
+	files := []testFile{
+		{[]string{"ok.bin"}, patternData(100, 1)},
+		{[]string{"..", "..", "evil.bin"}, patternData(100, 2)},
+	}
+	raw, _ := buildTestTorrent("pack", 16*1024, files)
+	tr, err := ParseTorrent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pe *PathError
+	if err := tr.CheckPaths(); !errors.As(err, &pe) || pe.Index != 1 || pe.Component != ".." {
+		t.Fatalf("check: %v", err)
+	}
+
+	base := t.TempDir()
+	dir := filepath.Join(base, "a", "b")
+	s := NewStorage(tr, dir)
+	_, err = s.WriteAt(patternData(200, 0), 0)
+	s.Close()
+	if !errors.Is(err, UnsafePathError) {
+		t.Fatalf("expect UnsafePathError, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(base, "evil.bin")); err == nil {
+		t.Fatal("wrote outside the download directory")
+	}
+
+	tr.SetPathPolicy(PathEscape)
+	s = NewStorage(tr, dir)
+	defer s.Close()
+	if _, err := s.WriteAt(patternData(200, 0), 0); err != nil {
+		t.Fatal(err)
+	}
+	p, _ := s.FilePath(tr.Files()[1])
+	if !strings.HasPrefix(p, dir) || filepath.Base(filepath.Dir(p)) != "__" {
+		t.Fatalf("escaped to %v", p)
+	}
+
+	raw, _ = buildTestTorrent("..", 16*1024, files[:1])
+	tr, _ = ParseTorrent(raw)
+	if _, err := tr.SafePath(tr.Files()[0]); !errors.As(err, &pe) || pe.Index != -1 {
+		t.Fatalf("name: %v", err)
+	}
+}
@@ -0,0 +1,498 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultChokeInterval = 10 * time.Second
+
+	// requests beyond this many per peer are dropped
+	DefaultMaxQueuedRequests = 250
+
+	HandshakeTimeout = 30 * time.Second
+	IdleTimeout      = 2 * time.Minute
+)
+
+var (
+	NothingToSeedError = errors.New("no verified piece to seed")
+	BadRequestError    = errors.New("invalid block request")
+	SeederClosedError  = errors.New("seeder closed")
+)
+
+// Seeder accepts inbound peers for the torrents added to it and serves
+// the pieces that passed verification.
+type Seeder struct {
+	PeerID            [20]byte
+	UploadSlots       int
+	ChokeInterval     time.Duration
+	MaxQueuedRequests int
+
+	// methods accepted from peers that open with the encryption handshake;
+	// zero only speaks the plain protocol
+	Encryption CryptoMethod
+
+	mu       sync.Mutex
+	torrents map[[20]byte]*seedTorrent
+	peers    map[*seedPeer]bool
+	choker   *Choker
+	lns      []net.Listener
+	closed   chan struct{}
+	started  bool
+	wg       sync.WaitGroup
+}
+
+type seedTorrent struct {
+	t       *Torrent
+	storage *Storage
+	have    Bitfield
+}
+
+type seedPeer struct {
+	s   *Seeder
+	st  *seedTorrent
+	pc  *PeerConn
+	ext *ExtensionSession
+	key string
+
+	mu         sync.Mutex
+	choked     bool
+	interested bool
+	queue      []Message
+	uploaded   int64
+	theirs     Bitfield
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+func NewSeeder() *Seeder {
+	return &Seeder{
+		PeerID:            GeneratePeerID(),
+		UploadSlots:       DefaultUploadSlots,
+		ChokeInterval:     DefaultChokeInterval,
+		MaxQueuedRequests: DefaultMaxQueuedRequests,
+		torrents:          make(map[[20]byte]*seedTorrent),
+		peers:             make(map[*seedPeer]bool),
+		closed:            make(chan struct{}),
+	}
+}
+
+// AddTorrent verifies the data of t under dir and starts serving it.
+// The returned bitfield holds the pieces that will be served.
+func (s *Seeder) AddTorrent(t *Torrent, dir string) (Bitfield, error) {
+	storage := NewStorage(t, dir)
+	have := storage.Verify()
+	if have.Count() == 0 {
+		storage.Close()
+		return have, NothingToSeedError
+	}
+	log.Printf("seeding %v: %v/%v piece(s)", t.Name(), have.Count(), t.PieceCount())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.torrents[t.InfoHash()]; ok {
+		old.storage.Close()
+	}
+	s.torrents[t.InfoHash()] = &seedTorrent{t: t, storage: storage, have: have}
+	return have, nil
+}
+
+func (s *Seeder) RemoveTorrent(infoHash [20]byte) {
+	s.mu.Lock()
+	st, ok := s.torrents[infoHash]
+	delete(s.torrents, infoHash)
+	var victims []*seedPeer
+	for p := range s.peers {
+		if p.st == st {
+			victims = append(victims, p)
+		}
+	}
+	s.mu.Unlock()
+	for _, p := range victims {
+		p.pc.Close()
+	}
+	if ok {
+		st.storage.Close()
+	}
+}
+
+// Listen starts accepting TCP peers on addr in the background.
+func (s *Seeder) Listen(addr string) (net.Addr, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	go s.Serve(ln)
+	return ln.Addr(), nil
+}
+
+// ListenUTP starts accepting uTP peers on the UDP addr in the background.
+func (s *Seeder) ListenUTP(addr string) (net.Addr, error) {
+	ln, err := ListenUTP(addr)
+	if err != nil {
+		return nil, err
+	}
+	go s.Serve(ln)
+	return ln.Addr(), nil
+}
+
+// Serve accepts peers on ln until the seeder is closed.
+func (s *Seeder) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	select {
+	case <-s.closed:
+		s.mu.Unlock()
+		ln.Close()
+		return SeederClosedError
+	default:
+	}
+	s.lns = append(s.lns, ln)
+	s.startChoker()
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-s.closed:
+				return SeederClosedError
+			default:
+			}
+			return err
+		}
+		// Close waits for the handlers it has seen; later ones must not start
+		s.mu.Lock()
+		select {
+		case <-s.closed:
+			s.mu.Unlock()
+			conn.Close()
+			return SeederClosedError
+		default:
+		}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			if err := s.HandleConn(conn); err != nil && err != io.EOF {
+				log.Printf("peer %v: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func (s *Seeder) Close() error {
+	s.mu.Lock()
+	select {
+	case <-s.closed:
+		s.mu.Unlock()
+		return nil
+	default:
+	}
+	close(s.closed)
+	for _, ln := range s.lns {
+		ln.Close()
+	}
+	for p := range s.peers {
+		p.pc.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, st := range s.torrents {
+		st.storage.Close()
+	}
+	return nil
+}
+
+// must hold s.mu
+func (s *Seeder) startChoker() {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.choker = NewChoker(s.UploadSlots)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.ChokeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.closed:
+				return
+			case <-ticker.C:
+				s.rechoke()
+			}
+		}
+	}()
+}
+
+// HandleConn runs the inbound side of one peer connection, from the
+// handshake on. Other transports can hand their connections in here.
+func (s *Seeder) HandleConn(conn net.Conn) error {
+	defer conn.Close()
+	if s.Encryption != 0 {
+		var err error
+		if conn, err = AcceptMaybeEncrypted(conn, s.infoHashes(), s.Encryption, HandshakeTimeout); err != nil {
+			return err
+		}
+	}
+	pc := NewPeerConn(conn)
+	pc.ReadTimeout = HandshakeTimeout
+	pc.WriteTimeout = HandshakeTimeout
+
+	remote, err := pc.ReadHandshake()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	st, ok := s.torrents[remote.InfoHash]
+	s.mu.Unlock()
+	if !ok {
+		return InfoHashError
+	}
+	h := Handshake{InfoHash: remote.InfoHash, PeerID: s.PeerID}
+	h.SetExtensionProtocol()
+	if err := pc.WriteHandshake(h); err != nil {
+		return err
+	}
+	if err := pc.WriteMessage(BitfieldMessage(st.have)); err != nil {
+		return err
+	}
+
+	p := &seedPeer{
+		s:      s,
+		st:     st,
+		pc:     pc,
+		choked: true,
+		theirs: NewBitfield(st.t.PieceCount()),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	p.key = fmt.Sprintf("%v/%p", conn.RemoteAddr(), p)
+
+	if remote.SupportsExtensionProtocol() {
+		registry := NewExtensionRegistry()
+		registry.Register(NewMetadataExtension(st.t))
+		p.ext = NewExtensionSession(registry, pc)
+		err := p.ext.SendHandshake(ExtendedHandshake{
+			V:            ClientVersion,
+			Reqq:         int64(s.MaxQueuedRequests),
+			MetadataSize: int64(len(st.t.RawInfo())),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	select {
+	case <-s.closed:
+		s.mu.Unlock()
+		return SeederClosedError
+	default:
+	}
+	s.peers[p] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.peers, p)
+		s.mu.Unlock()
+		close(p.done)
+	}()
+
+	go p.upload()
+	pc.ReadTimeout = IdleTimeout
+	pc.WriteTimeout = IdleTimeout
+	for {
+		m, err := pc.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if err := p.handle(m); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Seeder) infoHashes() [][20]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rvs [][20]byte
+	for ih := range s.torrents {
+		rvs = append(rvs, ih)
+	}
+	return rvs
+}
+
+func (p *seedPeer) handle(m Message) error {
+	if m.KeepAlive {
+		return nil
+	}
+	switch m.ID {
+	case MsgInterested:
+		p.mu.Lock()
+		p.interested = true
+		p.mu.Unlock()
+		p.s.unchokeIfFree(p)
+	case MsgNotInterested:
+		p.mu.Lock()
+		p.interested = false
+		p.mu.Unlock()
+	case MsgHave:
+		p.mu.Lock()
+		p.theirs.Set(int(m.Index))
+		p.mu.Unlock()
+	case MsgBitfield:
+		if err := Bitfield(m.Bitfield).Validate(p.st.t.PieceCount()); err != nil {
+			return err
+		}
+		p.mu.Lock()
+		p.theirs = Bitfield(m.Bitfield).Clone()
+		p.mu.Unlock()
+	case MsgRequest:
+		if err := p.checkRequest(m); err != nil {
+			return err
+		}
+		p.mu.Lock()
+		// requests from a choked peer are dropped
+		if !p.choked && len(p.queue) < p.s.MaxQueuedRequests {
+			p.queue = append(p.queue, m)
+		}
+		p.mu.Unlock()
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	case MsgCancel:
+		p.mu.Lock()
+		for i, q := range p.queue {
+			if q.Index == m.Index && q.Begin == m.Begin && q.Length == m.Length {
+				p.queue = append(p.queue[:i], p.queue[i+1:]...)
+				break
+			}
+		}
+		p.mu.Unlock()
+	case MsgExtended:
+		if p.ext != nil {
+			if _, err := p.ext.HandleMessage(m); err != nil {
+				log.Printf("extended message from %v: %v", p.key, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *seedPeer) checkRequest(m Message) error {
+	t := p.st.t
+	if int(m.Index) >= t.PieceCount() || !p.st.have.Has(int(m.Index)) ||
+		m.Length == 0 || m.Length > BlockSize ||
+		int64(m.Begin)+int64(m.Length) > t.PieceSize(int(m.Index)) {
+		return fmt.Errorf("%w: %v", BadRequestError, m)
+	}
+	return nil
+}
+
+func (p *seedPeer) upload() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.wake:
+		}
+		for {
+			p.mu.Lock()
+			if p.choked || len(p.queue) == 0 {
+				p.mu.Unlock()
+				break
+			}
+			req := p.queue[0]
+			p.queue = p.queue[1:]
+			p.mu.Unlock()
+
+			block := make([]byte, req.Length)
+			off := p.st.storage.PieceOffset(int(req.Index)) + int64(req.Begin)
+			if _, err := p.st.storage.ReadAt(block, off); err != nil {
+				log.Printf("reading block %v: %v", req, err)
+				p.pc.Close()
+				return
+			}
+			if err := p.pc.WriteMessage(PieceMessage(req.Index, req.Begin, block)); err != nil {
+				return
+			}
+			p.mu.Lock()
+			p.uploaded += int64(len(block))
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (p *seedPeer) setChoked(choked bool) {
+	p.mu.Lock()
+	if p.choked == choked {
+		p.mu.Unlock()
+		return
+	}
+	p.choked = choked
+	if choked {
+		p.queue = nil
+	}
+	p.mu.Unlock()
+	if choked {
+		p.pc.WriteMessage(ChokeMessage())
+	} else {
+		p.pc.WriteMessage(UnchokeMessage())
+	}
+}
+
+// unchokeIfFree gives a newly interested peer a slot right away when one
+// is unused, instead of waiting for the next round.
+func (s *Seeder) unchokeIfFree(p *seedPeer) {
+	s.mu.Lock()
+	unchoked := 0
+	for q := range s.peers {
+		q.mu.Lock()
+		if !q.choked {
+			unchoked++
+		}
+		q.mu.Unlock()
+	}
+	s.mu.Unlock()
+	if unchoked < s.UploadSlots {
+		p.setChoked(false)
+	}
+}
+
+func (s *Seeder) rechoke() {
+	s.mu.Lock()
+	var peers []*seedPeer
+	for p := range s.peers {
+		peers = append(peers, p)
+	}
+	s.mu.Unlock()
+
+	var cands []ChokeCandidate
+	secs := s.ChokeInterval.Seconds()
+	for _, p := range peers {
+		p.mu.Lock()
+		cands = append(cands, ChokeCandidate{
+			Key:        p.key,
+			Interested: p.interested,
+			UploadRate: float64(p.uploaded) / secs,
+		})
+		p.uploaded = 0
+		p.mu.Unlock()
+	}
+	unchoke := s.choker.Rechoke(cands, true)
+	for _, p := range peers {
+		p.setChoked(!unchoke[p.key])
+	}
+}
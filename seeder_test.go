@@ -0,0 +1,103 @@
+package bencode
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSeederServesBlocks(t *testing.T) {
+	dir := t.TempDir()
+	files := []testFile{
+		{[]string{"a.bin"}, patternData(40000, 1)},
+		{[]string{"sub", "b.bin"}, patternData(30000, 2)},
+	}
+	raw, all := buildTestTorrent("seed", 32*1024, files)
+	tr, err := ParseTorrent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, dir, "seed", files)
+
+	s := NewSeeder()
+	defer s.Close()
+	have, err := s.AddTorrent(tr, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have.Count() != tr.PieceCount() {
+		t.Fatalf("verified %v/%v", have.Count(), tr.PieceCount())
+	}
+	addr, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc, _, err := DialPeer(addr.String(), Handshake{InfoHash: tr.InfoHash(), PeerID: GeneratePeerID()}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	pc.ReadTimeout = 5 * time.Second
+
+	m, err := pc.ReadMessage()
+	if err != nil || m.ID != MsgBitfield || !bytes.Equal(m.Bitfield, have) {
+		t.Fatalf("expect bitfield, got %v %v", m, err)
+	}
+	pc.WriteMessage(InterestedMessage())
+	if m, err := pc.ReadMessage(); err != nil || m.ID != MsgUnchoke {
+		t.Fatalf("expect unchoke, got %v %v", m, err)
+	}
+
+	// the second piece spans both files
+	pc.WriteMessage(RequestMessage(1, 0, BlockSize))
+	pc.WriteMessage(RequestMessage(1, BlockSize, BlockSize))
+	got := make([]byte, 32*1024)
+	for i := 0; i < 2; i++ {
+		m, err := pc.ReadMessage()
+		if err != nil || m.ID != MsgPiece || m.Index != 1 {
+			t.Fatalf("expect piece, got %v %v", m, err)
+		}
+		copy(got[m.Begin:], m.Block)
+	}
+	if !bytes.Equal(got, all[32*1024:64*1024]) {
+		t.Fatalf("block data mismatch")
+	}
+
+	// out of range: the seeder hangs up
+	pc.WriteMessage(RequestMessage(uint32(tr.PieceCount()), 0, BlockSize))
+	if m, err := pc.ReadMessage(); err == nil {
+		t.Fatalf("expect the connection to be closed, got %v", m)
+	}
+
+	// an unknown info-hash is refused at the handshake
+	if _, _, err := DialPeer(addr.String(), Handshake{PeerID: GeneratePeerID()}, 5*time.Second); err == nil {
+		t.Fatalf("unknown info-hash shall be refused")
+	}
+}
+
+func TestChoker(t *testing.T) {
+	c := NewChoker(3)
+	cands := []ChokeCandidate{
+		{Key: "slow", Interested: true, DownloadRate: 10},
+		{Key: "fast", Interested: true, DownloadRate: 1000},
+		{Key: "mid", Interested: true, DownloadRate: 100},
+		{Key: "snub", Interested: true, DownloadRate: 5000, Snubbed: true},
+		{Key: "idle", Interested: false, DownloadRate: 9999},
+	}
+	for round := 0; round < 6; round++ {
+		u := c.Rechoke(cands, false)
+		if len(u) != 3 || !u["fast"] || !u["mid"] || u["idle"] {
+			t.Fatalf("round %v: %v", round, u)
+		}
+	}
+
+	// seeding ranks by upload rate
+	u := NewChoker(2).Rechoke([]ChokeCandidate{
+		{Key: "a", Interested: true, UploadRate: 1},
+		{Key: "b", Interested: true, UploadRate: 50},
+	}, true)
+	if !u["a"] || !u["b"] {
+		t.Fatalf("seeding: %v", u)
+	}
+}
@@ -0,0 +1,274 @@
+package bencode
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+var (
+	OutOfRangeError = errors.New("offset out of torrent range")
+)
+
+// Storage maps torrent offsets onto the files laid out under Dir, the way
+// a client saves them: Dir/name for a single file, Dir/name/path... otherwise.
+type Storage struct {
+	Dir string
+
+	t     *Torrent
+	files []FileEntry
+
+	mu    sync.Mutex
+	open  map[int]*os.File
+	write map[int]bool
+	stale []*os.File
+}
+
+func NewStorage(t *Torrent, dir string) *Storage {
+	return &Storage{
+		Dir:   dir,
+		t:     t,
+		files: t.Files(),
+		open:  make(map[int]*os.File),
+		write: make(map[int]bool),
+	}
+}
+
+func (s *Storage) Torrent() *Torrent {
+	return s.t
+}
+
+// FilePath is where fe lives under Dir. Unsafe names in the torrent are
+// refused or escaped as its path policy says.
+func (s *Storage) FilePath(fe FileEntry) (string, error) {
+	ps, err := s.t.SafePath(fe)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(append([]string{s.Dir}, ps...)...), nil
+}
+
+// span is the part of one file covered by a torrent range
+type span struct {
+	file    FileEntry
+	fileOff int64
+	bufOff  int64
+	length  int64
+}
+
+func (s *Storage) spans(off, length int64) ([]span, error) {
+	return fileSpans(s.files, off, length)
+}
+
+// fileSpans splits the torrent range off+length into per-file parts.
+func fileSpans(files []FileEntry, off, length int64) ([]span, error) {
+	var total int64
+	if n := len(files); n > 0 {
+		total = files[n-1].Offset + files[n-1].Length
+	}
+	if off < 0 || length < 0 || off+length > total {
+		return nil, fmt.Errorf("%w: %v+%v", OutOfRangeError, off, length)
+	}
+	// first file ending after off
+	i := sort.Search(len(files), func(i int) bool {
+		return files[i].Offset+files[i].Length > off
+	})
+	var rvs []span
+	end := off + length
+	for cur := off; cur < end && i < len(files); i++ {
+		fe := files[i]
+		if fe.Length == 0 {
+			continue
+		}
+		n := fe.Offset + fe.Length - cur
+		if n > end-cur {
+			n = end - cur
+		}
+		rvs = append(rvs, span{file: fe, fileOff: cur - fe.Offset, bufOff: cur - off, length: n})
+		cur += n
+	}
+	return rvs, nil
+}
+
+func (s *Storage) file(fe FileEntry, writable bool) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.open[fe.Index]; ok && (s.write[fe.Index] || !writable) {
+		return f, nil
+	} else if ok {
+		// a reader may still be using it, close it along with the rest
+		s.stale = append(s.stale, f)
+		delete(s.open, fe.Index)
+	}
+	p, err := s.FilePath(fe)
+	if err != nil {
+		return nil, err
+	}
+	var f *os.File
+	if writable {
+		if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return nil, err
+		}
+		mode := os.FileMode(0644)
+		if fe.IsExecutable() {
+			mode = 0755
+		}
+		f, err = os.OpenFile(p, os.O_RDWR|os.O_CREATE, mode)
+	} else {
+		f, err = os.Open(p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.open[fe.Index] = f
+	s.write[fe.Index] = writable
+	return f, nil
+}
+
+// ReadAt reads torrent data at the torrent offset off, across file boundaries.
+// Padding reads as zeros.
+func (s *Storage) ReadAt(p []byte, off int64) (int, error) {
+	spans, err := s.spans(off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, sp := range spans {
+		if sp.file.IsPadding() {
+			buf := p[sp.bufOff : sp.bufOff+sp.length]
+			for i := range buf {
+				buf[i] = 0
+			}
+			total += len(buf)
+			continue
+		}
+		f, err := s.file(sp.file, false)
+		if err != nil {
+			return total, err
+		}
+		n, err := f.ReadAt(p[sp.bufOff:sp.bufOff+sp.length], sp.fileOff)
+		total += n
+		if err == io.EOF && int64(n) < sp.length {
+			return total, io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// WriteAt writes torrent data, creating directories and files as needed.
+// Padding is not written.
+func (s *Storage) WriteAt(p []byte, off int64) (int, error) {
+	spans, err := s.spans(off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, sp := range spans {
+		if sp.file.IsPadding() {
+			total += int(sp.length)
+			continue
+		}
+		f, err := s.file(sp.file, true)
+		if err != nil {
+			return total, err
+		}
+		n, err := f.WriteAt(p[sp.bufOff:sp.bufOff+sp.length], sp.fileOff)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func (s *Storage) PieceOffset(index int) int64 {
+	return int64(index) * s.t.PieceLength()
+}
+
+func (s *Storage) ReadPiece(index int) ([]byte, error) {
+	buf := make([]byte, s.t.PieceSize(index))
+	if _, err := s.ReadAt(buf, s.PieceOffset(index)); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (s *Storage) WritePiece(index int, data []byte) error {
+	_, err := s.WriteAt(data, s.PieceOffset(index))
+	return err
+}
+
+func (s *Storage) VerifyPiece(index int) (bool, error) {
+	data, err := s.ReadPiece(index)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(calcSha1Hash(data), s.t.PieceHash(index)), nil
+}
+
+// Verify hashes every piece. Missing or short files only fail the pieces
+// they cover.
+func (s *Storage) Verify() Bitfield {
+	bf := NewBitfield(s.t.PieceCount())
+	for i := 0; i < s.t.PieceCount(); i++ {
+		if ok, _ := s.VerifyPiece(i); ok {
+			bf.Set(i)
+		}
+	}
+	return bf
+}
+
+// VerifyFileSHA1 checks a whole file against its BEP 47 sha1.
+func (s *Storage) VerifyFileSHA1(fe FileEntry) (bool, error) {
+	if len(fe.SHA1) == 0 {
+		return false, fmt.Errorf("%w: file %v", NoFileHashError, fe.Index)
+	}
+	h := sha1.New()
+	if _, err := io.Copy(h, io.NewSectionReader(s, fe.Offset, fe.Length)); err != nil {
+		return false, err
+	}
+	return bytes.Equal(h.Sum(nil), fe.SHA1), nil
+}
+
+// CreateEmpty creates the zero-length files, which no piece ever writes.
+func (s *Storage) CreateEmpty() error {
+	for _, fe := range s.files {
+		if fe.Length != 0 || fe.IsPadding() {
+			continue
+		}
+		f, err := s.file(fe, true)
+		if err != nil {
+			return err
+		}
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var first error
+	for idx, f := range s.open {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+		delete(s.open, idx)
+	}
+	for _, f := range s.stale {
+		f.Close()
+	}
+	s.stale = nil
+	return first
+}
@@ -46,7 +46,17 @@ func SetSingleHashEnabled(enabled bool) {
 }
 
 type Torrent struct {
-	info map[string]BNode
+	info    map[string]BNode
+	rawInfo []byte
+	meta    map[string]BNode
+
+	// pieces that failed the last VerifyAll
+	failedPieces []int
+
+	fm     *FileMan
+	fmOnce sync.Once
+
+	pathPolicy PathPolicy
 }
 
 func NewTorrent(infoMap map[string]BNode) *Torrent {
@@ -65,10 +75,13 @@ func (t *Torrent) PrintSummary() {
 	info := t.info
 	files := info["files"]
 	for _, file := range files.List {
+		if isPaddingInfo(file.Map) {
+			continue
+		}
 		length := *file.Map["length"].Int
 		var pathes []string
 		for _, name := range file.Map["path"].List {
-			pathes = append(pathes, *name.Str)
+			pathes = append(pathes, name.AsString())
 		}
 		pathstr := strings.Join(pathes, "/")
 		fmt.Printf("%16s byte(s)\t%v\n", strconv.FormatInt(length, 10), pathstr)
@@ -79,7 +92,7 @@ func (t *Torrent) PrintSummary() {
 	pieceLength := *info["piece length"].Int
 	fmt.Printf("%24s:\t%v\n", "piece length", pieceLength)
 
-	pieces := info["pieces"].Binary
+	pieces := info["pieces"].AsBinary()
 	pieceBinLength := len(pieces)
 
 	fmt.Printf("%24s:\t%v\n", "piece sha1 length", pieceBinLength)
@@ -135,15 +148,10 @@ func locateIndex(info map[string]BNode, filename string, size int64) (idx int, l
 	return
 }
 
-func locateFile(info map[string]BNode, index int) (string, int64) {
-	fi := info["files"].AsList()[index].AsMap()
-	pathLS := fi["path"].AsList()
-	var paths []string
-	for _, el := range pathLS {
-		paths = append(paths, el.AsString())
-	}
+func (t *Torrent) locateFile(index int) (string, int64) {
+	fi := t.info["files"].AsList()[index].AsMap()
 	length := fi["length"].AsInt()
-	return path.Join(paths...), length
+	return t.toPathName(fi), length
 }
 
 func (t *Torrent) GetTotalLength() int64 {
@@ -323,6 +331,21 @@ func (t *Torrent) checkMain(filename string,
 	return
 }
 
+// SetFileMan sets where neighbouring files are looked up when pieces at
+// the edges of a file are checked. By default the working directory is
+// indexed on first use.
+func (t *Torrent) SetFileMan(fm *FileMan) {
+	t.fmOnce.Do(func() {})
+	t.fm = fm
+}
+
+func (t *Torrent) fileMan() *FileMan {
+	t.fmOnce.Do(func() {
+		t.fm = NewFileMan()
+	})
+	return t.fm
+}
+
 func (t *Torrent) fixHeadTail(okPieces, headPiece, tailPiece, notOkPiece int32,
 	filename string,
 	idx int,
@@ -333,7 +356,7 @@ func (t *Torrent) fixHeadTail(okPieces, headPiece, tailPiece, notOkPiece int32,
 	if headPiece > 0 || tailPiece > 0 {
 		log.Printf("### fixing with head-piece: %v, tail-piece: %v ###", headPiece, tailPiece)
 		log.Printf("### prev-margin: %v, post-margin: %v", prevMargin, postMargin)
-		fm := NewFileMan()
+		fm := t.fileMan()
 		fileInfos := t.info["files"].AsList()
 
 		// Processing with the head
@@ -344,10 +367,10 @@ func (t *Torrent) fixHeadTail(okPieces, headPiece, tailPiece, notOkPiece int32,
 				var headBuff []byte
 				remainPrev := int64(prevMargin)
 				for elIdx := idx - 1; elIdx >= 0 && remainPrev > 0; elIdx-- {
-					origin, length := locateFile(t.info, elIdx)
-					that, ok := fm.Lookup(origin, length)
-					if !ok {
-						log.Printf("cannot find %v", origin)
+					origin, length := t.locateFile(elIdx)
+					that, err := fm.LookupEntry(t, elIdx)
+					if err != nil {
+						log.Printf("cannot find %v: %v", origin, err)
 						return
 					}
 
@@ -413,10 +436,10 @@ func (t *Torrent) fixHeadTail(okPieces, headPiece, tailPiece, notOkPiece int32,
 				var tailBuff []byte
 				remainPost := postMargin
 				for elIdx := idx + 1; elIdx < len(fileInfos) && remainPost > 0; elIdx++ {
-					origin, length := locateFile(t.info, elIdx)
-					that, ok := fm.Lookup(origin, length)
-					if !ok {
-						log.Printf("cannot find %v", origin)
+					origin, length := t.locateFile(elIdx)
+					that, err := fm.LookupEntry(t, elIdx)
+					if err != nil {
+						log.Printf("cannot find %v: %v", origin, err)
 						return
 					}
 
@@ -562,12 +585,10 @@ func (t *Torrent) GetFileList() []string {
 	fileInfos := t.info["files"].AsList()
 	var rvs []string
 	for _, v := range fileInfos {
-		pathlist := v.AsMap()["path"].AsList()
-		var ps []string
-		for _, p := range pathlist {
-			ps = append(ps, p.AsString())
+		if isPaddingInfo(v.AsMap()) {
+			continue
 		}
-		rvs = append(rvs, strings.Join(ps, "/"))
+		rvs = append(rvs, strings.Join(t.pathComponents(v.AsMap()), "/"))
 	}
 	return rvs
 }
@@ -595,13 +616,17 @@ func (t *Torrent) VerifyAll() (bool, error) {
 
 	totFileCount := len(fileInfos)
 	tempBuff := make([]byte, pieceLength)
+	t.failedPieces = nil
 
 	for i := 0; i < blockCount; i++ {
 		for myBuffer.Len() < int(pieceLength) {
 			if thisRemains <= 0 && nil == curFin && iFileIdx < totFileCount {
 				lengthForThisFile := fileInfos[iFileIdx].AsMap()["length"].AsInt()
 				thisRemains = lengthForThisFile
-				curFin = loadFile(fileInfos[iFileIdx].AsMap())
+				// padding is zeros, not worth a look on disk
+				if !isPaddingInfo(fileInfos[iFileIdx].AsMap()) {
+					curFin = t.loadFile(iFileIdx, fileInfos[iFileIdx].AsMap())
+				}
 				iFileIdx++
 				if curFin == nil {
 					zeroBuffer = bytes.NewBuffer(make([]byte, lengthForThisFile))
@@ -653,6 +678,7 @@ func (t *Torrent) VerifyAll() (bool, error) {
 			// fmt.Printf(".")
 		} else {
 			failed++
+			t.failedPieces = append(t.failedPieces, i)
 			fmt.Printf("block<%d> Target<%v> Current<%v>\n", i,
 				hex.EncodeToString(thisPiece),
 				hex.EncodeToString(result),
@@ -670,6 +696,12 @@ func (t *Torrent) VerifyAll() (bool, error) {
 	return true, nil
 }
 
+// FailedPieces returns the pieces that failed the last VerifyAll,
+// ready to be handed to Repair.
+func (t *Torrent) FailedPieces() []int {
+	return append([]int(nil), t.failedPieces...)
+}
+
 // func loadLoadChunk(fileinfo map[string]BNode) []byte {
 // 	pathLs := fileinfo["path"].AsList()
 
@@ -690,20 +722,30 @@ func (t *Torrent) VerifyAll() (bool, error) {
 // 	return nil
 // }
 
-func toPathName(fileinfo map[string]BNode) string {
-	pathLs := fileinfo["path"].AsList()
+// pathComponents is the path of a files entry as Files has it: path.utf-8
+// when valid, or else decoded through the declared encoding.
+func (t *Torrent) pathComponents(fileinfo map[string]BNode) []string {
+	if alt, ok := utf8PathAlternate(fileinfo); ok {
+		return alt
+	}
 	var pathArr []string
-	for _, v := range pathLs {
-		pathArr = append(pathArr, v.AsString())
+	for _, v := range fileinfo["path"].AsList() {
+		pathArr = append(pathArr, t.decodeName(v.AsString()))
 	}
+	return pathArr
+}
+
+// for display only, unsafe components come out escaped
+func (t *Torrent) toPathName(fileinfo map[string]BNode) string {
+	pathArr, _ := sanitizePath(-1, t.pathComponents(fileinfo), PathEscape)
 	return path.Join(pathArr...)
 }
 
-func loadFile(fileinfo map[string]BNode) *os.File {
-	pathLs := fileinfo["path"].AsList()
-	var pathArr []string
-	for _, v := range pathLs {
-		pathArr = append(pathArr, v.AsString())
+func (t *Torrent) loadFile(index int, fileinfo map[string]BNode) *os.File {
+	pathArr, err := sanitizePath(index, t.pathComponents(fileinfo), t.pathPolicy)
+	if err != nil {
+		log.Print(err)
+		return nil
 	}
 	tl := len(pathArr)
 	for i := len(pathArr); i >= 1; i-- {
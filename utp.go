@@ -0,0 +1,966 @@
+package bencode
+
+// uTP - uTorrent transport protocol
+// http://bittorrent.org/beps/bep_0029.html
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	utpData  = 0
+	utpFin   = 1
+	utpState = 2
+	utpReset = 3
+	utpSyn   = 4
+
+	utpVersion      = 1
+	utpHeaderLength = 20
+	utpExtSack      = 1
+
+	// payload bytes per packet, below the usual path MTU
+	UTPMaxPayload = 1200
+
+	// bytes a connection buffers for the reader
+	UTPRecvBuffer = 1 << 20
+
+	// LEDBAT queuing delay target
+	UTPTargetDelay = 100 * time.Millisecond
+
+	utpMaxGain        = 3000 // window growth per rtt, in bytes
+	utpMinWindow      = 2 * UTPMaxPayload
+	utpInitialWindow  = 4 * UTPMaxPayload
+	utpMaxOutOfOrder  = UTPRecvBuffer / UTPMaxPayload
+	utpInitialRTO     = time.Second
+	utpMinRTO         = 500 * time.Millisecond
+	utpMaxRTO         = 8 * time.Second
+	utpMaxTimeouts    = 7
+	utpTick           = 50 * time.Millisecond
+	utpAcceptBacklog  = 32
+	utpMaxDatagramLen = 64 * 1024
+)
+
+const (
+	utpSynSent = iota
+	utpConnected
+)
+
+var (
+	UTPFormatError  = errors.New("malformed utp packet")
+	UTPResetError   = errors.New("utp connection reset by peer")
+	UTPTimeoutError = errors.New("utp connection timed out")
+)
+
+var utpEpoch = time.Now()
+
+func utpMicros(t time.Time) uint32 {
+	return uint32(t.Sub(utpEpoch).Microseconds())
+}
+
+// sequence numbers wrap at 16 bits
+func seqLess(a, b uint16) bool {
+	return int16(a-b) < 0
+}
+
+type utpHeader struct {
+	typ    byte
+	connID uint16
+	ts     uint32
+	tsDiff uint32
+	wnd    uint32
+	seq    uint16
+	ack    uint16
+	sack   []byte
+}
+
+func (h utpHeader) marshal(payload []byte) []byte {
+	b := make([]byte, utpHeaderLength, utpHeaderLength+2+len(h.sack)+len(payload))
+	b[0] = h.typ<<4 | utpVersion
+	if h.sack != nil {
+		b[1] = utpExtSack
+	}
+	binary.BigEndian.PutUint16(b[2:], h.connID)
+	binary.BigEndian.PutUint32(b[4:], h.ts)
+	binary.BigEndian.PutUint32(b[8:], h.tsDiff)
+	binary.BigEndian.PutUint32(b[12:], h.wnd)
+	binary.BigEndian.PutUint16(b[16:], h.seq)
+	binary.BigEndian.PutUint16(b[18:], h.ack)
+	if h.sack != nil {
+		b = append(b, 0, byte(len(h.sack)))
+		b = append(b, h.sack...)
+	}
+	return append(b, payload...)
+}
+
+func parseUTPPacket(b []byte) (utpHeader, []byte, error) {
+	var h utpHeader
+	if len(b) < utpHeaderLength {
+		return h, nil, fmt.Errorf("%w: %v byte(s)", UTPFormatError, len(b))
+	}
+	h.typ = b[0] >> 4
+	if b[0]&0xf != utpVersion || h.typ > utpSyn {
+		return h, nil, fmt.Errorf("%w: type/version %#x", UTPFormatError, b[0])
+	}
+	h.connID = binary.BigEndian.Uint16(b[2:])
+	h.ts = binary.BigEndian.Uint32(b[4:])
+	h.tsDiff = binary.BigEndian.Uint32(b[8:])
+	h.wnd = binary.BigEndian.Uint32(b[12:])
+	h.seq = binary.BigEndian.Uint16(b[16:])
+	h.ack = binary.BigEndian.Uint16(b[18:])
+	ext, rest := b[1], b[utpHeaderLength:]
+	for ext != 0 {
+		if len(rest) < 2 || len(rest) < 2+int(rest[1]) {
+			return h, nil, fmt.Errorf("%w: truncated extension %v", UTPFormatError, ext)
+		}
+		next, n := rest[0], int(rest[1])
+		if ext == utpExtSack {
+			h.sack = rest[2 : 2+n]
+		}
+		ext, rest = next, rest[2+n:]
+	}
+	return h, rest, nil
+}
+
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+type utpKey struct {
+	addr string
+	id   uint16
+}
+
+// UTPSocket multiplexes uTP connections over one UDP socket. It is a
+// net.Listener, and dials out from the same port.
+type UTPSocket struct {
+	pc        net.PacketConn
+	listening bool
+	owned     bool // closed along with its last connection
+
+	mu        sync.Mutex
+	conns     map[utpKey]*UTPConn
+	backlog   chan *UTPConn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// ListenUTP opens a UDP socket on addr and accepts uTP connections on it.
+func ListenUTP(addr string) (*UTPSocket, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewUTPSocket(pc), nil
+}
+
+// NewUTPSocket runs uTP over pc, which the socket owns from now on.
+func NewUTPSocket(pc net.PacketConn) *UTPSocket {
+	return newUTPSocket(pc, true, false)
+}
+
+func newUTPSocket(pc net.PacketConn, listening, owned bool) *UTPSocket {
+	s := &UTPSocket{
+		pc:        pc,
+		listening: listening,
+		owned:     owned,
+		conns:     make(map[utpKey]*UTPConn),
+		backlog:   make(chan *UTPConn, utpAcceptBacklog),
+		closed:    make(chan struct{}),
+	}
+	go s.readLoop()
+	go s.tickLoop()
+	return s
+}
+
+// DialUTP connects to addr from a fresh socket that goes away with the
+// connection. It fits Downloader.Dial.
+func DialUTP(addr string, timeout time.Duration) (net.Conn, error) {
+	pc, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	s := newUTPSocket(pc, false, true)
+	c, err := s.Dial(addr, timeout)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *UTPSocket) Addr() net.Addr {
+	return s.pc.LocalAddr()
+}
+
+func (s *UTPSocket) Accept() (net.Conn, error) {
+	select {
+	case c := <-s.backlog:
+		return c, nil
+	case <-s.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close resets every connection still open and releases the UDP socket.
+func (s *UTPSocket) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.mu.Lock()
+		conns := s.conns
+		s.conns = make(map[utpKey]*UTPConn)
+		s.mu.Unlock()
+		for _, c := range conns {
+			c.abort()
+		}
+		s.pc.Close()
+	})
+	return nil
+}
+
+// Dial opens a connection to addr, waiting up to timeout for the peer
+// to answer.
+func (s *UTPSocket) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	select {
+	case <-s.closed:
+		s.mu.Unlock()
+		return nil, net.ErrClosed
+	default:
+	}
+	var id uint16
+	for {
+		id = uint16(rand.Uint32())
+		_, used := s.conns[utpKey{raddr.String(), id}]
+		_, used2 := s.conns[utpKey{raddr.String(), id + 1}]
+		if !used && !used2 {
+			break
+		}
+	}
+	c := s.newConn(raddr, id, id+1)
+	s.conns[utpKey{raddr.String(), id}] = c
+	s.mu.Unlock()
+
+	c.mu.Lock()
+	c.outq = append(c.outq, &utpPacket{typ: utpSyn, seq: c.seqNr})
+	c.seqNr++
+	c.flush(time.Now())
+	c.mu.Unlock()
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	select {
+	case <-c.connected:
+		return c, nil
+	case <-c.dead:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return nil, c.err
+	case <-timer:
+		c.Close()
+		return nil, fmt.Errorf("%w: dialing %v", UTPTimeoutError, addr)
+	}
+}
+
+func (s *UTPSocket) newConn(raddr net.Addr, recvID, sendID uint16) *UTPConn {
+	return &UTPConn{
+		sock:      s,
+		raddr:     raddr,
+		recvID:    recvID,
+		sendID:    sendID,
+		seqNr:     1,
+		cwnd:      utpInitialWindow,
+		ssthresh:  UTPRecvBuffer,
+		peerWnd:   UTPRecvBuffer,
+		rto:       utpInitialRTO,
+		lastWnd:   UTPRecvBuffer,
+		ooo:       make(map[uint16][]byte),
+		readable:  make(chan struct{}, 1),
+		writable:  make(chan struct{}, 1),
+		connected: make(chan struct{}),
+		dead:      make(chan struct{}),
+	}
+}
+
+func (s *UTPSocket) forget(c *UTPConn) {
+	s.mu.Lock()
+	key := utpKey{c.raddr.String(), c.recvID}
+	found := s.conns[key] == c
+	if found {
+		delete(s.conns, key)
+	}
+	last := found && s.owned && len(s.conns) == 0
+	s.mu.Unlock()
+	if last {
+		go s.Close()
+	}
+}
+
+func (s *UTPSocket) writeTo(b []byte, addr net.Addr) {
+	s.pc.WriteTo(b, addr)
+}
+
+func (s *UTPSocket) readLoop() {
+	defer s.Close()
+	buf := make([]byte, utpMaxDatagramLen)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		h, payload, err := parseUTPPacket(buf[:n])
+		if err != nil {
+			continue
+		}
+		s.dispatch(h, payload, addr)
+	}
+}
+
+func (s *UTPSocket) dispatch(h utpHeader, payload []byte, addr net.Addr) {
+	now := time.Now()
+	key := utpKey{addr.String(), h.connID}
+	if h.typ == utpSyn {
+		key.id++
+	}
+	s.mu.Lock()
+	c := s.conns[key]
+	if c == nil && h.typ == utpReset {
+		// a reset carries either of our ids
+		if c = s.conns[utpKey{key.addr, h.connID + 1}]; c == nil || c.sendID != h.connID {
+			c = s.conns[utpKey{key.addr, h.connID - 1}]
+			if c != nil && c.sendID != h.connID {
+				c = nil
+			}
+		}
+	}
+	accepted := false
+	if c == nil && h.typ == utpSyn && s.listening {
+		c = s.newConn(addr, h.connID+1, h.connID)
+		c.state = utpConnected
+		c.seqNr = uint16(rand.Uint32())
+		c.ackNr = h.seq
+		close(c.connected)
+		s.conns[key] = c
+		accepted = true
+	}
+	s.mu.Unlock()
+
+	switch {
+	case c == nil:
+		if h.typ != utpReset {
+			s.writeTo(utpHeader{typ: utpReset, connID: h.connID, ts: utpMicros(now), ack: h.seq}.marshal(nil), addr)
+		}
+	case accepted:
+		select {
+		case s.backlog <- c:
+			c.incoming(h, payload, now)
+		default:
+			s.writeTo(utpHeader{typ: utpReset, connID: h.connID, ts: utpMicros(now), ack: h.seq}.marshal(nil), addr)
+			s.forget(c)
+		}
+	default:
+		c.incoming(h, payload, now)
+	}
+}
+
+func (s *UTPSocket) tickLoop() {
+	ticker := time.NewTicker(utpTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			conns := make([]*UTPConn, 0, len(s.conns))
+			for _, c := range s.conns {
+				conns = append(conns, c)
+			}
+			s.mu.Unlock()
+			for _, c := range conns {
+				c.tick(now)
+			}
+		}
+	}
+}
+
+type utpPacket struct {
+	typ      byte
+	seq      uint16
+	payload  []byte
+	sentAt   time.Time
+	sends    int
+	inFlight bool
+	acked    bool
+}
+
+// UTPConn is one uTP connection. It satisfies net.Conn, so a PeerConn
+// can run over it as over TCP.
+type UTPConn struct {
+	sock           *UTPSocket
+	raddr          net.Addr
+	recvID, sendID uint16
+
+	mu    sync.Mutex
+	state int
+	seqNr uint16 // next to send
+	ackNr uint16 // last received in order
+
+	// sender
+	outq       []*utpPacket
+	queued     int // unacked payload bytes
+	inflight   int // payload bytes on the wire
+	cwnd       float64
+	ssthresh   float64
+	peerWnd    int
+	recoverSeq uint16
+	rtt        time.Duration
+	rttVar     time.Duration
+	rto        time.Duration
+	timeouts   int
+	curBase    uint32
+	prevBase   uint32
+	baseAt     time.Time
+
+	// receiver
+	replyMicro uint32
+	readBuf    []byte
+	ooo        map[uint16][]byte
+	oooBytes   int
+	gotFin     bool
+	finSeq     uint16
+	eof        bool
+	lastWnd    int
+
+	closed bool
+	err    error
+	rdl    time.Time
+	wdl    time.Time
+
+	readable  chan struct{}
+	writable  chan struct{}
+	connected chan struct{}
+	dead      chan struct{}
+}
+
+func (c *UTPConn) LocalAddr() net.Addr {
+	return c.sock.Addr()
+}
+
+func (c *UTPConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func (c *UTPConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *UTPConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.rdl = t
+	c.mu.Unlock()
+	wake(c.readable)
+	return nil
+}
+
+func (c *UTPConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.wdl = t
+	c.mu.Unlock()
+	wake(c.writable)
+	return nil
+}
+
+func (c *UTPConn) wait(ch chan struct{}, deadline time.Time) error {
+	var timer <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timer = t.C
+	}
+	select {
+	case <-ch:
+	case <-c.dead:
+	case <-timer:
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
+
+func (c *UTPConn) Read(b []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+		if len(c.readBuf) > 0 {
+			n := copy(b, c.readBuf)
+			c.readBuf = c.readBuf[n:]
+			if len(c.readBuf) == 0 {
+				c.readBuf = nil
+			}
+			// tell a stalled sender the window opened again
+			if c.err == nil && c.lastWnd < UTPRecvBuffer/4 && c.recvWindow() >= UTPRecvBuffer/2 {
+				c.send(utpState, c.seqNr, nil)
+			}
+			c.mu.Unlock()
+			return n, nil
+		}
+		if c.eof {
+			c.mu.Unlock()
+			return 0, io.EOF
+		}
+		if c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return 0, err
+		}
+		deadline := c.rdl
+		c.mu.Unlock()
+		if err := c.wait(c.readable, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write returns once b is queued within the send window; delivery is up
+// to the retransmission machinery.
+func (c *UTPConn) Write(b []byte) (int, error) {
+	n := 0
+	for len(b) > 0 {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return n, net.ErrClosed
+		}
+		if c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return n, err
+		}
+		size := len(b)
+		if size > UTPMaxPayload {
+			size = UTPMaxPayload
+		}
+		if c.queued == 0 || c.queued+size <= c.window() {
+			p := &utpPacket{typ: utpData, seq: c.seqNr, payload: append([]byte(nil), b[:size]...)}
+			c.seqNr++
+			c.outq = append(c.outq, p)
+			c.queued += size
+			c.flush(time.Now())
+			c.mu.Unlock()
+			b = b[size:]
+			n += size
+			continue
+		}
+		deadline := c.wdl
+		c.mu.Unlock()
+		if err := c.wait(c.writable, deadline); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Close sends a FIN after the queued data and lingers in the background
+// until it is acknowledged.
+func (c *UTPConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.readBuf = nil
+	if c.err == nil {
+		if c.state != utpConnected {
+			c.fail(net.ErrClosed)
+			return nil
+		}
+		c.outq = append(c.outq, &utpPacket{typ: utpFin, seq: c.seqNr})
+		c.seqNr++
+		c.flush(time.Now())
+	}
+	wake(c.readable)
+	wake(c.writable)
+	return nil
+}
+
+// must hold c.mu
+func (c *UTPConn) fail(err error) {
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	c.outq = nil
+	close(c.dead)
+	wake(c.readable)
+	wake(c.writable)
+	c.sock.forget(c)
+}
+
+// abort is the socket going away under the connection.
+func (c *UTPConn) abort() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	if c.state == utpConnected {
+		c.send(utpReset, c.seqNr, nil)
+	}
+	c.err = net.ErrClosed
+	c.outq = nil
+	close(c.dead)
+	wake(c.readable)
+	wake(c.writable)
+}
+
+func (c *UTPConn) window() int {
+	w := int(c.cwnd)
+	if c.peerWnd < w {
+		w = c.peerWnd
+	}
+	return w
+}
+
+func (c *UTPConn) recvWindow() int {
+	w := UTPRecvBuffer - len(c.readBuf) - c.oooBytes
+	if w < 0 {
+		return 0
+	}
+	return w
+}
+
+// must hold c.mu
+func (c *UTPConn) send(typ byte, seq uint16, payload []byte) {
+	h := utpHeader{
+		typ:    typ,
+		connID: c.sendID,
+		ts:     utpMicros(time.Now()),
+		tsDiff: c.replyMicro,
+		wnd:    uint32(c.recvWindow()),
+		seq:    seq,
+		ack:    c.ackNr,
+	}
+	if typ == utpSyn {
+		h.connID = c.recvID
+	} else if len(c.ooo) > 0 {
+		h.sack = c.sackMask()
+	}
+	c.lastWnd = int(h.wnd)
+	c.sock.writeTo(h.marshal(payload), c.raddr)
+}
+
+// bit i stands for ack_nr+2+i, least significant bit first
+func (c *UTPConn) sackMask() []byte {
+	var bits [32]byte
+	top := -1
+	for seq := range c.ooo {
+		i := int(seq - c.ackNr - 2)
+		if i < len(bits)*8 {
+			bits[i/8] |= 1 << (i % 8)
+			if i > top {
+				top = i
+			}
+		}
+	}
+	if top < 0 {
+		return nil
+	}
+	return append([]byte(nil), bits[:(top/32+1)*4]...)
+}
+
+// flush puts unsent and lost packets on the wire as the window allows.
+func (c *UTPConn) flush(now time.Time) {
+	for _, p := range c.outq {
+		if p.inFlight || p.acked {
+			continue
+		}
+		if c.inflight > 0 && c.inflight+len(p.payload) > c.window() {
+			break
+		}
+		p.inFlight = true
+		p.sends++
+		p.sentAt = now
+		c.inflight += len(p.payload)
+		c.send(p.typ, p.seq, p.payload)
+	}
+}
+
+func (c *UTPConn) incoming(h utpHeader, payload []byte, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.replyMicro = utpMicros(now) - h.ts
+	c.peerWnd = int(h.wnd)
+	switch h.typ {
+	case utpReset:
+		c.fail(UTPResetError)
+		return
+	case utpSyn:
+		// the peer missed our answer
+		c.send(utpState, c.seqNr, nil)
+		return
+	}
+	if c.state == utpSynSent {
+		if len(c.outq) == 0 || h.ack != c.outq[0].seq {
+			return
+		}
+		c.state = utpConnected
+		c.ackNr = h.seq - 1
+		close(c.connected)
+	}
+	c.processAck(h, now)
+	if h.typ == utpData || h.typ == utpFin {
+		c.receive(h, payload)
+		c.send(utpState, c.seqNr, nil)
+	}
+	c.flush(now)
+	c.maybeDone()
+}
+
+func (c *UTPConn) receive(h utpHeader, payload []byte) {
+	if h.typ == utpFin && !c.gotFin {
+		c.gotFin = true
+		c.finSeq = h.seq
+	}
+	off := h.seq - c.ackNr
+	if off == 0 || off > utpMaxOutOfOrder {
+		return
+	}
+	if h.typ == utpData {
+		if c.gotFin && seqLess(c.finSeq, h.seq) {
+			return
+		}
+		if _, ok := c.ooo[h.seq]; ok {
+			return
+		}
+		if len(c.readBuf)+c.oooBytes+len(payload) > UTPRecvBuffer {
+			return
+		}
+		var data []byte
+		if !c.closed {
+			data = append([]byte(nil), payload...)
+		}
+		c.ooo[h.seq] = data
+		c.oooBytes += len(data)
+	}
+	for {
+		next := c.ackNr + 1
+		if data, ok := c.ooo[next]; ok {
+			delete(c.ooo, next)
+			c.oooBytes -= len(data)
+			c.readBuf = append(c.readBuf, data...)
+			c.ackNr = next
+			continue
+		}
+		if c.gotFin && next == c.finSeq {
+			c.ackNr = next
+			c.eof = true
+		}
+		break
+	}
+	wake(c.readable)
+}
+
+func (c *UTPConn) processAck(h utpHeader, now time.Time) {
+	if !seqLess(h.ack, c.seqNr) {
+		return
+	}
+	acked, bytes := 0, 0
+	for len(c.outq) > 0 && !seqLess(h.ack, c.outq[0].seq) {
+		p := c.outq[0]
+		c.outq[0] = nil
+		c.outq = c.outq[1:]
+		if !p.acked {
+			acked++
+			bytes += c.ackPacket(p, now)
+		}
+	}
+	if h.sack != nil {
+		for _, p := range c.outq {
+			i := int(p.seq - h.ack - 2)
+			if i < len(h.sack)*8 && h.sack[i/8]&(1<<(i%8)) != 0 && !p.acked {
+				acked++
+				bytes += c.ackPacket(p, now)
+			}
+		}
+		c.detectLoss()
+	}
+	if acked > 0 {
+		c.timeouts = 0
+		c.congestion(bytes, h.tsDiff, now)
+		wake(c.writable)
+	}
+}
+
+func (c *UTPConn) ackPacket(p *utpPacket, now time.Time) int {
+	p.acked = true
+	if p.inFlight {
+		p.inFlight = false
+		c.inflight -= len(p.payload)
+	}
+	c.queued -= len(p.payload)
+	// Karn: retransmitted packets give no rtt sample
+	if p.sends == 1 {
+		c.sampleRTT(now.Sub(p.sentAt))
+	}
+	return len(p.payload)
+}
+
+func (c *UTPConn) sampleRTT(sample time.Duration) {
+	if c.rtt == 0 {
+		c.rtt, c.rttVar = sample, sample/2
+	} else {
+		delta := c.rtt - sample
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttVar += (delta - c.rttVar) / 4
+		c.rtt += (sample - c.rtt) / 8
+	}
+	c.rto = c.rtt + 4*c.rttVar
+	if c.rto < utpMinRTO {
+		c.rto = utpMinRTO
+	}
+}
+
+// A packet skipped over by three selective acks is taken as lost.
+func (c *UTPConn) detectLoss() {
+	lost, later := false, 0
+	for i := len(c.outq) - 1; i >= 0; i-- {
+		p := c.outq[i]
+		if p.acked {
+			later++
+			continue
+		}
+		if later >= 3 && p.inFlight && p.sends == 1 {
+			p.inFlight = false
+			c.inflight -= len(p.payload)
+			if !seqLess(p.seq, c.recoverSeq) {
+				lost = true
+			}
+		}
+	}
+	if lost {
+		// once per window
+		c.cwnd /= 2
+		if c.cwnd < utpMinWindow {
+			c.cwnd = utpMinWindow
+		}
+		c.ssthresh = c.cwnd
+		c.recoverSeq = c.seqNr
+	}
+}
+
+// congestion grows the window by LEDBAT, after a slow start that lasts
+// while the queuing delay stays low.
+func (c *UTPConn) congestion(acked int, delay uint32, now time.Time) {
+	var ourDelay time.Duration
+	if delay != 0 {
+		ourDelay = time.Duration(delay-c.baseDelay(delay, now)) * time.Microsecond
+	}
+	if c.cwnd < c.ssthresh && ourDelay < UTPTargetDelay/2 {
+		c.cwnd += float64(acked)
+	} else {
+		offTarget := float64(UTPTargetDelay-ourDelay) / float64(UTPTargetDelay)
+		if offTarget < -1 {
+			offTarget = -1
+		}
+		c.cwnd += utpMaxGain * offTarget * float64(acked) / c.cwnd
+	}
+	if c.cwnd < utpMinWindow {
+		c.cwnd = utpMinWindow
+	}
+	if c.cwnd > UTPRecvBuffer {
+		c.cwnd = UTPRecvBuffer
+	}
+}
+
+// baseDelay is the lowest delay sample over the last one to two minutes.
+func (c *UTPConn) baseDelay(sample uint32, now time.Time) uint32 {
+	if c.baseAt.IsZero() {
+		c.curBase, c.prevBase, c.baseAt = sample, sample, now
+	} else if now.Sub(c.baseAt) > time.Minute {
+		c.prevBase, c.curBase, c.baseAt = c.curBase, sample, now
+	} else if int32(sample-c.curBase) < 0 {
+		c.curBase = sample
+	}
+	if int32(c.prevBase-c.curBase) < 0 {
+		return c.prevBase
+	}
+	return c.curBase
+}
+
+func (c *UTPConn) tick(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	for _, p := range c.outq {
+		if !p.inFlight || p.acked {
+			continue
+		}
+		if now.Sub(p.sentAt) <= c.rto {
+			break
+		}
+		c.timeouts++
+		if c.timeouts > utpMaxTimeouts {
+			c.fail(UTPTimeoutError)
+			return
+		}
+		c.rto *= 2
+		if c.rto > utpMaxRTO {
+			c.rto = utpMaxRTO
+		}
+		// everything out there is presumed lost
+		for _, q := range c.outq {
+			q.inFlight = false
+		}
+		c.inflight = 0
+		c.ssthresh = c.cwnd / 2
+		if c.ssthresh < utpMinWindow {
+			c.ssthresh = utpMinWindow
+		}
+		c.cwnd = utpMinWindow
+		c.recoverSeq = c.seqNr
+		c.flush(now)
+		break
+	}
+	c.maybeDone()
+}
+
+// a closed connection is done once its FIN is acknowledged
+func (c *UTPConn) maybeDone() {
+	if c.closed && c.err == nil && len(c.outq) == 0 {
+		c.fail(net.ErrClosed)
+	}
+}
@@ -0,0 +1,198 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lossyPacketConn drops and delays outgoing datagrams.
+type lossyPacketConn struct {
+	net.PacketConn
+	loss  float64
+	delay time.Duration
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func (l *lossyPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	l.mu.Lock()
+	drop := l.rnd.Float64() < l.loss
+	l.mu.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	if l.delay == 0 {
+		return l.PacketConn.WriteTo(b, addr)
+	}
+	buf := append([]byte(nil), b...)
+	time.AfterFunc(l.delay, func() { l.PacketConn.WriteTo(buf, addr) })
+	return len(b), nil
+}
+
+func lossySocket(t *testing.T, loss float64, delay time.Duration, seed int64) *UTPSocket {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewUTPSocket(&lossyPacketConn{PacketConn: pc, loss: loss, delay: delay, rnd: rand.New(rand.NewSource(seed))})
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// transfer sends data one way and checks it arrives intact, then EOF.
+func utpTransfer(t *testing.T, ln *UTPSocket, dial func() (net.Conn, error), data []byte) {
+	got := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			got <- nil
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		got <- b
+	}()
+	conn, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := conn.Write(data); err != nil || n != len(data) {
+		t.Fatalf("write %v: %v", n, err)
+	}
+	conn.Close()
+	select {
+	case b := <-got:
+		if !bytes.Equal(b, data) {
+			t.Fatalf("received %v byte(s), want %v", len(b), len(data))
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("transfer stalled")
+	}
+}
+
+func TestUTPLoopback(t *testing.T) {
+	ln, err := ListenUTP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	data := patternData(3<<20, 1)
+	utpTransfer(t, ln, func() (net.Conn, error) {
+		return DialUTP(ln.Addr().String(), 5*time.Second)
+	}, data)
+
+	// echo in both directions over one connection
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	conn, err := DialUTP(ln.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	data = patternData(200000, 2)
+	go conn.Write(data)
+	back := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, back); err != nil || !bytes.Equal(back, data) {
+		t.Fatalf("echo: %v", err)
+	}
+}
+
+func TestUTPLossAndDelay(t *testing.T) {
+	for _, loss := range []float64{0.05, 0.15} {
+		ln := lossySocket(t, loss, 5*time.Millisecond, 1)
+		client := lossySocket(t, loss, 5*time.Millisecond, 2)
+		utpTransfer(t, ln, func() (net.Conn, error) {
+			return client.Dial(ln.Addr().String(), 10*time.Second)
+		}, patternData(300000, 3))
+	}
+}
+
+func TestUTPDeadlineAndReset(t *testing.T) {
+	ln, err := ListenUTP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conn, err := DialUTP(ln.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	peer, _ := ln.Accept()
+
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	var ne net.Error
+	if _, err := conn.Read(make([]byte, 1)); !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("expect timeout, got %v", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	// the socket going away resets its connections
+	peer.Write([]byte("x"))
+	ln.Close()
+	buf := make([]byte, 4)
+	if n, err := conn.Read(buf); n != 1 || err != nil {
+		t.Fatalf("read %v: %v", n, err)
+	}
+	if _, err := conn.Read(buf); !errors.Is(err, UTPResetError) {
+		t.Fatalf("expect UTPResetError, got %v", err)
+	}
+
+	// nobody listening there any more
+	if _, err := DialUTP(ln.Addr().String(), time.Second); err == nil {
+		t.Fatal("dial to a closed socket succeeded")
+	}
+}
+
+func TestUTPPacket(t *testing.T) {
+	h := utpHeader{typ: utpData, connID: 7, ts: 1, tsDiff: 2, wnd: 3, seq: 65535, ack: 9, sack: []byte{1, 0, 0, 0}}
+	b := h.marshal([]byte("data"))
+	g, payload, err := parseUTPPacket(b)
+	if err != nil || string(payload) != "data" || g.seq != 65535 || g.ack != 9 || !bytes.Equal(g.sack, h.sack) {
+		t.Fatalf("round trip: %+v %q %v", g, payload, err)
+	}
+	if _, _, err := parseUTPPacket(b[:25]); !errors.Is(err, UTPFormatError) {
+		t.Fatalf("expect UTPFormatError, got %v", err)
+	}
+	if !seqLess(65535, 0) || seqLess(0, 65535) {
+		t.Fatal("sequence numbers do not wrap")
+	}
+}
+
+func TestSeederOverUTP(t *testing.T) {
+	files := []testFile{{[]string{"utp.bin"}, patternData(200000, 5)}}
+	raw, _ := buildTestTorrent("utp", 32*1024, files)
+	tr, _ := ParseTorrent(raw)
+	seedDir := t.TempDir()
+	writeTestFiles(t, seedDir, "utp", files)
+
+	s := NewSeeder()
+	defer s.Close()
+	if _, err := s.AddTorrent(tr, seedDir); err != nil {
+		t.Fatal(err)
+	}
+	addr, err := s.ListenUTP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	d := NewDownloader(tr, dir, []string{addr.String()})
+	d.Dial = DialUTP
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	checkTestFiles(t, dir, "utp", files)
+}
@@ -0,0 +1,214 @@
+package bencode
+
+// WebSeed - HTTP/FTP Seeding (GetRight style)
+// http://bittorrent.org/beps/bep_0019.html
+// HTTP Seeding (Hoffman style)
+// http://bittorrent.org/beps/bep_0017.html
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	WebSeedError     = errors.New("web seed error")
+	WebSeedBusyError = errors.New("web seed busy")
+)
+
+// WebSeed is one url-list entry (BEP 19), or one httpseeds entry when
+// HTTPSeed is set (BEP 17).
+type WebSeed struct {
+	URL      string
+	HTTPSeed bool
+	Client   *http.Client
+}
+
+// WebSeeds collects url-list, which may be a string or a list, and httpseeds.
+func (t *Torrent) WebSeeds() []WebSeed {
+	var rvs []WebSeed
+	meta := t.Meta()
+	if ul, ok := meta["url-list"]; ok {
+		switch ul.Cat {
+		case BNodeString:
+			if ul.AsString() != "" {
+				rvs = append(rvs, WebSeed{URL: ul.AsString()})
+			}
+		case BNodeList:
+			for _, u := range ul.List {
+				if u.IsBytes() && u.AsString() != "" {
+					rvs = append(rvs, WebSeed{URL: u.AsString()})
+				}
+			}
+		}
+	}
+	if hs, ok := meta["httpseeds"]; ok && hs.Cat == BNodeList {
+		for _, u := range hs.List {
+			if u.IsBytes() && u.AsString() != "" {
+				rvs = append(rvs, WebSeed{URL: u.AsString(), HTTPSeed: true})
+			}
+		}
+	}
+	return rvs
+}
+
+func (ws WebSeed) client() *http.Client {
+	if ws.Client != nil {
+		return ws.Client
+	}
+	return &http.Client{Timeout: time.Minute}
+}
+
+// FileURL applies the BEP 19 layout rules: a url ending in a slash gets
+// the torrent name appended, and for multi-file torrents the file path.
+func (ws WebSeed) FileURL(t *Torrent, fe FileEntry) string {
+	u := ws.URL
+	if !t.IsMultiFile() {
+		if strings.HasSuffix(u, "/") {
+			u += url.PathEscape(t.Name())
+		}
+		return u
+	}
+	if !strings.HasSuffix(u, "/") {
+		u += "/"
+	}
+	parts := []string{url.PathEscape(t.Name())}
+	for _, p := range fe.Path {
+		parts = append(parts, url.PathEscape(p))
+	}
+	return u + strings.Join(parts, "/")
+}
+
+// FetchPiece downloads one piece. The data is not verified.
+func (ws WebSeed) FetchPiece(t *Torrent, index int) ([]byte, error) {
+	if index < 0 || index >= t.PieceCount() {
+		return nil, fmt.Errorf("%w: piece %v", OutOfRangeError, index)
+	}
+	if ws.HTTPSeed {
+		return ws.fetchHTTPSeed(t, index)
+	}
+	size := t.PieceSize(index)
+	spans, err := fileSpans(t.Files(), int64(index)*t.PieceLength(), size)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	for _, sp := range spans {
+		if sp.file.IsPadding() {
+			// servers do not host padding files, buf starts out zeroed
+			continue
+		}
+		err := ws.fetchRange(ws.FileURL(t, sp.file), sp.fileOff, buf[sp.bufOff:sp.bufOff+sp.length])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func (ws WebSeed) fetchRange(u string, off int64, buf []byte) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(buf))-1))
+	resp, err := ws.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// the server ignored the range, skip to it
+		if _, err := io.CopyN(io.Discard, resp.Body, off); err != nil {
+			return fmt.Errorf("%w: %v: %v", WebSeedError, u, err)
+		}
+	case http.StatusServiceUnavailable:
+		return fmt.Errorf("%w: %v", WebSeedBusyError, u)
+	default:
+		return fmt.Errorf("%w: %v: %v", WebSeedError, u, resp.Status)
+	}
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		return fmt.Errorf("%w: %v: %v", WebSeedError, u, err)
+	}
+	return nil
+}
+
+// BEP 17: url?info_hash=...&piece=N, a 503 carries the seconds to wait
+func (ws WebSeed) fetchHTTPSeed(t *Torrent, index int) ([]byte, error) {
+	ih := t.InfoHash()
+	sep := "?"
+	if strings.Contains(ws.URL, "?") {
+		sep = "&"
+	}
+	u := ws.URL + sep + "info_hash=" + url.QueryEscape(string(ih[:])) + "&piece=" + strconv.Itoa(index)
+	resp, err := ws.client().Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, t.PieceLength()+1))
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusServiceUnavailable:
+		return nil, fmt.Errorf("%w: retry in %v second(s)", WebSeedBusyError, strings.TrimSpace(string(body)))
+	default:
+		return nil, fmt.Errorf("%w: %v: %v", WebSeedError, ws.URL, resp.Status)
+	}
+	if int64(len(body)) != t.PieceSize(index) {
+		return nil, fmt.Errorf("%w: piece %v of %v byte(s)", WebSeedError, index, len(body))
+	}
+	return body, nil
+}
+
+// FetchPieces makes a WebSeed a RepairSource. Pieces are verified before
+// they are written.
+func (ws WebSeed) FetchPieces(s *Storage, pieces []int) error {
+	t := s.Torrent()
+	missing := 0
+	for _, i := range pieces {
+		data, err := ws.FetchPiece(t, i)
+		if err == nil && !bytes.Equal(calcSha1Hash(data), t.PieceHash(i)) {
+			err = fmt.Errorf("%w: piece %v fails the hash check", WebSeedError, i)
+		}
+		if err != nil {
+			log.Printf("web seed %v: %v", ws.URL, err)
+			missing++
+			continue
+		}
+		if err := s.WritePiece(i, data); err != nil {
+			return err
+		}
+	}
+	if missing > 0 {
+		return fmt.Errorf("%w: %v piece(s) not fetched from %v", IncompleteError, missing, ws.URL)
+	}
+	return nil
+}
+
+// DownloadWebSeeds completes the data under dir from web seeds, those of
+// the torrent when none are given.
+func (t *Torrent) DownloadWebSeeds(dir string, seeds ...WebSeed) error {
+	if len(seeds) == 0 {
+		seeds = t.WebSeeds()
+	}
+	var sources []RepairSource
+	for _, ws := range seeds {
+		sources = append(sources, ws)
+	}
+	s := NewStorage(t, dir)
+	defer s.Close()
+	_, err := Repair(s, s.Verify().Missing(t.PieceCount()), sources...)
+	return err
+}
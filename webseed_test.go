@@ -0,0 +1,158 @@
+package bencode
+
+import (
+	"crypto/sha1"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWebSeedMultiFile(t *testing.T) {
+	files := []testFile{
+		{[]string{"a b.bin"}, patternData(40000, 3)},
+		{[]string{"sub", "c.bin"}, patternData(25000, 4)},
+	}
+	raw, _ := buildTestTorrent("web seed", 16*1024, files)
+	tr, _ := ParseTorrent(raw)
+	seedDir := t.TempDir()
+	writeTestFiles(t, seedDir, "web seed", files)
+
+	srv := httptest.NewServer(http.FileServer(http.Dir(seedDir)))
+	defer srv.Close()
+	tr.Meta()["url-list"] = BNode{List: []BNode{NewString(srv.URL + "/")}, Cat: BNodeList}
+
+	seeds := tr.WebSeeds()
+	if len(seeds) != 1 {
+		t.Fatalf("web seeds: %+v", seeds)
+	}
+	if u := seeds[0].FileURL(tr, tr.Files()[0]); u != srv.URL+"/web%20seed/a%20b.bin" {
+		t.Fatalf("file url: %v", u)
+	}
+
+	dir := t.TempDir()
+	if err := tr.DownloadWebSeeds(dir); err != nil {
+		t.Fatal(err)
+	}
+	checkTestFiles(t, dir, "web seed", files)
+
+	// repair a damaged piece spanning both files
+	corruptFile(t, filepath.Join(dir, "web seed", "sub", "c.bin"), 10, 5)
+	report, err := tr.Repair(dir, seeds[0])
+	if err != nil || len(report.Repaired) != 1 || report.Repaired[0] != 2 {
+		t.Fatalf("repair: %+v %v", report, err)
+	}
+	checkTestFiles(t, dir, "web seed", files)
+}
+
+func TestWebSeedSingleFile(t *testing.T) {
+	data := patternData(50000, 9)
+	var pieces []byte
+	for off := 0; off < len(data); off += 16 * 1024 {
+		end := off + 16*1024
+		if end > len(data) {
+			end = len(data)
+		}
+		h := sha1.Sum(data[off:end])
+		pieces = append(pieces, h[:]...)
+	}
+	tr := NewTorrent(map[string]BNode{
+		"name":         NewString("single.bin"),
+		"length":       NewInt(int64(len(data))),
+		"piece length": NewInt(16 * 1024),
+		"pieces":       NewBytes(pieces),
+	})
+
+	seedDir := t.TempDir()
+	os.WriteFile(filepath.Join(seedDir, "other-name.bin"), data, 0644)
+	srv := httptest.NewServer(http.FileServer(http.Dir(seedDir)))
+	defer srv.Close()
+
+	// a url without the trailing slash names the file itself
+	dir := t.TempDir()
+	if err := tr.DownloadWebSeeds(dir, WebSeed{URL: srv.URL + "/other-name.bin"}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(filepath.Join(dir, "single.bin"))
+	if string(got) != string(data) {
+		t.Fatalf("content mismatch")
+	}
+}
+
+func TestHTTPSeed(t *testing.T) {
+	files := []testFile{{[]string{"x.bin"}, patternData(40000, 5)}}
+	raw, _ := buildTestTorrent("hoffman", 16*1024, files)
+	tr, _ := ParseTorrent(raw)
+	seedDir := t.TempDir()
+	writeTestFiles(t, seedDir, "hoffman", files)
+	s := NewStorage(tr, seedDir)
+	defer s.Close()
+
+	var calls int32
+	ih := tr.InfoHash()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("30"))
+			return
+		}
+		if r.URL.Query().Get("info_hash") != string(ih[:]) {
+			http.NotFound(w, r)
+			return
+		}
+		piece, _ := strconv.Atoi(r.URL.Query().Get("piece"))
+		data, err := s.ReadPiece(piece)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+	tr.Meta()["httpseeds"] = BNode{List: []BNode{NewString(srv.URL + "/seed")}, Cat: BNodeList}
+
+	ws := tr.WebSeeds()[0]
+	if !ws.HTTPSeed {
+		t.Fatalf("expect a BEP 17 seed")
+	}
+	if _, err := ws.FetchPiece(tr, 0); !errors.Is(err, WebSeedBusyError) {
+		t.Fatalf("expect WebSeedBusyError, got %v", err)
+	}
+	dir := t.TempDir()
+	if err := tr.DownloadWebSeeds(dir); err != nil {
+		t.Fatal(err)
+	}
+	checkTestFiles(t, dir, "hoffman", files)
+}
+
+func TestWebSeedPadding(t *testing.T) {
+	files := []testFile{
+		{[]string{"a.bin"}, patternData(10000, 5)},
+		{[]string{".pad", "6384"}, make([]byte, 6384)},
+		{[]string{"b.bin"}, patternData(20000, 6)},
+	}
+	raw, _ := buildTestTorrent("padded", 16*1024, files)
+	tr, _ := ParseTorrent(raw)
+	tr.Info()["files"].List[1].Map["attr"] = NewString("p")
+
+	// the server only has the real files
+	seedDir := t.TempDir()
+	writeTestFiles(t, seedDir, "padded", []testFile{files[0], files[2]})
+	srv := httptest.NewServer(http.FileServer(http.Dir(seedDir)))
+	defer srv.Close()
+
+	ws := WebSeed{URL: srv.URL + "/"}
+	for i := 0; i < tr.PieceCount(); i++ {
+		data, err := ws.FetchPiece(tr, i)
+		if err != nil {
+			t.Fatalf("piece %v: %v", i, err)
+		}
+		if h := sha1.Sum(data); string(h[:]) != string(tr.PieceHash(i)) {
+			t.Fatalf("piece %v does not verify", i)
+		}
+	}
+}